@@ -0,0 +1,122 @@
+package foxcache
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var adminTemplate = template.Must(template.New("admin").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(time.DateTime)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>foxcache</title>
+<style>
+body{font-family:sans-serif}
+table{border-collapse:collapse}
+th,td{padding:4px 8px;text-align:left;border-bottom:1px solid #ccc}
+form{display:inline}
+.err{color:#c00}
+</style>
+</head>
+<body>
+<table>
+<tr><th>key</th><th>updated</th><th>expires</th><th>next run</th><th>last error</th><th></th></tr>
+{{range .}}<tr>
+<td>{{.Key}}</td>
+<td>{{time .Updated}}</td>
+<td>{{time .Expires}}</td>
+<td>{{time .NextRun}}</td>
+<td class="err">{{if .LastErr}}{{.LastErr}}{{end}}</td>
+<td><form method="post">
+<input type="hidden" name="key" value="{{.Key}}">
+<button name="action" value="refresh">refresh</button>
+<button name="action" value="invalidate">invalidate</button>
+</form>
+{{if .History}}<form method="post">
+<input type="hidden" name="key" value="{{.Key}}">
+<select name="id">{{range .History}}<option value="{{.ID}}">{{time .Updated}}</option>{{end}}</select>
+<button name="action" value="rollback">rollback</button>
+</form>{{end}}</td>
+</tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+type adminRow struct {
+	Status
+	History []HistoryEntry
+}
+
+// lists all data. post with key and action=refresh|invalidate, or
+// action=rollback with a history id. cross origin posts are rejected
+//
+// example usage: `http.Handle("/admin/cache", cache.AdminHandler())`
+func (c *Cache) AdminHandler() http.Handler {
+	return http.NewCrossOriginProtection().Handler(c.adminHandler())
+}
+
+func (c *Cache) adminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			var rows []adminRow
+			for _, status := range c.Status() {
+				history, err := c.History(status.Key)
+				if err != nil {
+					slog.Error(
+						"failed to get cache history",
+						"key", status.Key, "err", err.Error(),
+					)
+				}
+				rows = append(rows, adminRow{status, history})
+			}
+
+			err := adminTemplate.Execute(w, rows)
+			if err != nil {
+				slog.Error("failed to render cache admin", "err", err.Error())
+			}
+
+		case http.MethodPost:
+			key := r.FormValue("key")
+
+			var err error
+			switch r.FormValue("action") {
+			case "refresh":
+				err = c.Refresh(key)
+			case "invalidate":
+				err = c.Invalidate(key)
+			case "rollback":
+				err = c.Rollback(key, r.FormValue("id"))
+			default:
+				http.Error(w, "unknown action", http.StatusBadRequest)
+				return
+			}
+
+			// refresh failures show up as last error
+			if err != nil {
+				slog.Error(
+					"cache admin action failed",
+					"key", key, "err", err.Error(),
+				)
+			}
+
+			// request uri survives http.StripPrefix
+			http.Redirect(w, r, r.RequestURI, http.StatusSeeOther)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -1,81 +1,520 @@
 package foxcache
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"os"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/robfig/cron/v3"
 	"go.etcd.io/bbolt"
 )
 
+type Options struct {
+	// passed to cron.New unless Scheduler is set
+	CronOptions []cron.Option
+	// defaults to cron
+	Scheduler Scheduler
+	// defaults to the real clock
+	Clock Clock
+	// for each retrieve unless data sets its own. 0 waits forever
+	Timeout time.Duration
+	// defaults to JSONCodec
+	Codec Codec
+	// encrypts values at rest. see NewAESGCM
+	Encryption Encryption
+	// reads values stored before Encryption was set, or seeded from an
+	// unencrypted export. Init encrypts data, memo entries when next
+	// retrieved. only while migrating, since anyone who can write the store
+	// could add values
+	ReadUnencrypted bool
+	// cron spec to run Sweep on after init. empty never sweeps
+	SweepSpec string
+	// compact the store after sweeping if it supports it. with bolt, Init
+	// errors unless the store came from OpenBoltStore
+	Compact bool
+	// missing keys are stored from this Export file on init, like an embed.FS
+	// so fresh deploys don't fetch everything. stale seeds are refreshed
+	Seed fs.FS
+	// in Seed. defaults to cache.jsonl
+	SeedFile string
+	// init serves persisted data right away, even if expired, and refreshes
+	// in the background. use WaitReady to know when everything is fresh
+	RefreshInBackground bool
+}
+
+// owns a store and cron scheduler. data is registered with Init
+type Cache struct {
+	store       Store
+	codec       Codec
+	encryption  Encryption
+	unencrypted bool
+	scheduler   Scheduler
+	clock       Clock
+	timeout     time.Duration
+	background  bool
+	sweepSpec   string
+	compact     bool
+	sweeping    bool
+	seedFS      fs.FS
+	seedFile    string
+
+	// cancelled on shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// tracks in-flight refreshes
+	running      sync.WaitGroup
+	runningMutex sync.Mutex
+	shutdown     bool
+
+	// registered data by key, in order of registration
+	entries      map[string]DataInterface
+	entryKeys    []string
+	entriesMutex sync.RWMutex
+
+	// closed once all data has been fresh at least once
+	ready      chan struct{}
+	notReady   int
+	readyMutex sync.Mutex
+}
+
+var (
+	// set by the package level init function
+	defaultCache *Cache
+
+	ErrShutdown     = errors.New("cache was shut down")
+	ErrUnknownKey   = errors.New("key not registered")
+	ErrDuplicateKey = errors.New("key already registered")
+	// a memo key is a prefix of another key
+	ErrOverlappingKey = errors.New("key overlaps a memo")
+	// used by the cache itself
+	ErrReservedKey = errors.New("key is reserved")
+)
+
 var (
-	// to be set in the init function
-	currentDB     *bbolt.DB
-	currentBucket []byte
+	// data was stored with a different version or shape of type
+	errSchemaMismatch = errors.New("cache data schema mismatch")
 )
 
 type cacheData[T any] struct {
 	Data    T         `json:"data"`
 	Updated time.Time `json:"updated"`
 	Expires time.Time `json:"expires"`
+	// has to match or data is treated as missing
+	Version int    `json:"version,omitempty"`
+	Type    string `json:"type,omitempty"`
+	// for conditional http requests
+	Validators Validators `json:"validators,omitzero"`
 }
 
-func setCache[T any](key string, data cacheData[T]) error {
-	if currentDB == nil {
-		return errors.New("database not set")
+// returns what was stored
+func setCache[T any](c *Cache, key string, data cacheData[T]) ([]byte, error) {
+	if c.store == nil {
+		return nil, errors.New("store not set")
 	}
 
-	jsonBytes, err := json.Marshal(data)
+	bytes, err := encodeCache(c, key, data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return currentDB.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(currentBucket)
-		if bucket == nil {
-			return errors.New("bucket not found")
-		}
-
-		return bucket.Put([]byte(key), jsonBytes)
-	})
+	return bytes, c.store.Put(key, bytes)
 }
 
-func getCache[T any](key string) (*cacheData[T], error) {
-	if currentDB == nil {
-		return nil, errors.New("database not set")
+// expired data is returned too, check expires
+func getCache[T any](c *Cache, key string, version int) (*cacheData[T], error) {
+	if c.store == nil {
+		return nil, errors.New("store not set")
 	}
 
-	var bytes []byte
+	bytes, err := c.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
 
-	err := currentDB.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(currentBucket)
-		if bucket == nil {
-			return errors.New("bucket not found")
-		}
+	return decodeCache[T](c, key, version, bytes)
+}
 
-		found := bucket.Get([]byte(key))
-		bytes = make([]byte, len(found))
-		copy(bytes, found)
+// key is where the data is stored, encryption binds the value to it
+func encodeCache[T any](
+	c *Cache, key string, data cacheData[T],
+) ([]byte, error) {
+	data.Type = typeFingerprint[T]()
 
-		return nil
-	})
+	bytes, err := c.codec.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
 
+	return c.seal(key, bytes)
+}
+
+func decodeCache[T any](
+	c *Cache, key string, version int, bytes []byte,
+) (*cacheData[T], error) {
+	bytes, err := c.open(key, bytes)
 	if err != nil {
 		return nil, err
 	}
 
 	var cacheData cacheData[T]
 
-	err = json.Unmarshal(bytes, &cacheData)
+	err = c.codec.Unmarshal(bytes, &cacheData)
 	if err != nil {
 		return nil, err
 	}
 
-	if time.Now().After(cacheData.Expires) {
-		os.Remove("cache/" + key + ".json")
-		return nil, errors.New("cache data expired")
+	if cacheData.Version != version ||
+		cacheData.Type != typeFingerprint[T]() {
+		return nil, errSchemaMismatch
 	}
 
 	return &cacheData, nil
 }
+
+// stores data in a bbolt bucket
+func New(db *bbolt.DB, bucket []byte, opts Options) (*Cache, error) {
+	store, err := NewBoltStore(db, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWithStore(store, opts), nil
+}
+
+func NewWithStore(store Store, opts Options) *Cache {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	codec := opts.Codec
+	if codec == nil {
+		codec = JSONCodec
+	}
+
+	scheduler := opts.Scheduler
+	if scheduler == nil {
+		scheduler = newCronScheduler(opts.CronOptions...)
+	}
+
+	clock := opts.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	return &Cache{
+		store:       store,
+		codec:       codec,
+		encryption:  opts.Encryption,
+		unencrypted: opts.ReadUnencrypted,
+		scheduler:   scheduler,
+		clock:       clock,
+		timeout:     opts.Timeout,
+		background:  opts.RefreshInBackground,
+		sweepSpec:   opts.SweepSpec,
+		compact:     opts.Compact,
+		seedFS:      opts.Seed,
+		seedFile:    opts.SeedFile,
+		ctx:         ctx,
+		cancel:      cancel,
+		entries:     map[string]DataInterface{},
+		ready:       closedChan(),
+	}
+}
+
+func closedChan() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+// cancelled on shutdown. timeout overrides the cache timeout if set
+func (c *Cache) retrieveContext(
+	timeout time.Duration,
+) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		timeout = c.timeout
+	}
+
+	if timeout > 0 {
+		return context.WithTimeout(c.ctx, timeout)
+	}
+
+	return context.WithCancel(c.ctx)
+}
+
+// returns false once shut down. call running.Done after
+func (c *Cache) startRunning() bool {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+
+	if c.shutdown {
+		return false
+	}
+
+	c.running.Add(1)
+	return true
+}
+
+// stops cron, cancels in-flight retrieves and waits for them to return
+func (c *Cache) Shutdown(ctx context.Context) error {
+	c.runningMutex.Lock()
+	c.shutdown = true
+	c.runningMutex.Unlock()
+
+	c.cancel()
+	schedulerCtx := c.scheduler.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		<-schedulerCtx.Done()
+		c.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// registers data with this cache, fetches what isn't cached and starts cron.
+// nothing is started if any data is invalid
+func (c *Cache) Init(dataInterfaces []DataInterface) error {
+	var errs []error
+	for _, data := range dataInterfaces {
+		err := data.validate()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", data.key(), err))
+		}
+	}
+
+	var sweepSchedule cron.Schedule
+	if c.sweepSpec != "" && !c.sweeping {
+		var err error
+		sweepSchedule, err = cron.ParseStandard(c.sweepSpec)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"invalid sweep cron spec %q: %w", c.sweepSpec, err,
+			))
+		}
+	}
+
+	// New can't compact since the db isn't its own
+	bolt, ok := c.store.(*BoltStore)
+	if c.compact && ok && !bolt.handle.owned {
+		errs = append(errs, errors.New(
+			"compact needs a store from OpenBoltStore, pass it to NewWithStore",
+		))
+	}
+
+	levels, err := c.dependencyLevels(dataInterfaces)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	// only once, later inits would find their keys stored anyway
+	if c.seedFS != nil {
+		err = c.seed(c.seedFS, c.seedFile)
+		if err != nil {
+			return errors.New("failed to seed cache: " + err.Error())
+		}
+		c.seedFS = nil
+	}
+
+	err = c.register(dataInterfaces)
+	if err != nil {
+		return err
+	}
+
+	// before dependents are wired, since a refreshing dependency marks them
+	c.addNotReady(len(dataInterfaces))
+
+	for _, data := range dataInterfaces {
+		data.attach(c)
+	}
+
+	for _, data := range dataInterfaces {
+		for _, dependency := range data.dependencies() {
+			dependency.(dependable).addDependent(func() {
+				data.refresh()
+			})
+		}
+	}
+
+	// dependencies first
+	for _, level := range levels {
+		var wg sync.WaitGroup
+		for _, data := range level {
+			wg.Go(func() {
+				data.init(c)
+			})
+		}
+		wg.Wait()
+	}
+
+	if c.ctx.Err() != nil {
+		return ErrShutdown
+	}
+
+	// after registering so keys aren't mistaken for orphans
+	if sweepSchedule != nil {
+		c.scheduler.Schedule("@sweep", sweepSchedule, c.sweep)
+		c.sweeping = true
+	}
+
+	c.scheduler.Start()
+
+	return nil
+}
+
+func (c *Cache) register(dataInterfaces []DataInterface) error {
+	c.entriesMutex.Lock()
+	defer c.entriesMutex.Unlock()
+
+	var errs []error
+	seen := map[string]bool{}
+	for _, data := range dataInterfaces {
+		_, exists := c.entries[data.key()]
+		if exists || seen[data.key()] {
+			errs = append(errs, fmt.Errorf("%s: %w", data.key(), ErrDuplicateKey))
+		}
+		if data.key() == historyName {
+			errs = append(errs, fmt.Errorf("%s: %w", data.key(), ErrReservedKey))
+		}
+		seen[data.key()] = true
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	// a memo owns every key under its prefix, so nothing else can live there
+	all := slices.Collect(maps.Values(c.entries))
+	for i, data := range dataInterfaces {
+		for _, other := range slices.Concat(all, dataInterfaces[:i]) {
+			if data.owns(other.key()) || other.owns(data.key()) {
+				errs = append(errs, fmt.Errorf(
+					"%s: overlaps %s: %w", data.key(), other.key(), ErrOverlappingKey,
+				))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	for _, data := range dataInterfaces {
+		c.entries[data.key()] = data
+		c.entryKeys = append(c.entryKeys, data.key())
+	}
+
+	return nil
+}
+
+func (c *Cache) get(key string) (DataInterface, error) {
+	c.entriesMutex.RLock()
+	defer c.entriesMutex.RUnlock()
+
+	data, ok := c.entries[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, ErrUnknownKey)
+	}
+
+	return data, nil
+}
+
+// fetches fresh data right away
+func (c *Cache) Refresh(key string) error {
+	data, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	return data.refresh()
+}
+
+// deletes persisted data and marks it stale until the next cron run
+func (c *Cache) Invalidate(key string) error {
+	data, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	return data.invalidate()
+}
+
+// status of all registered data in order of registration
+func (c *Cache) Status() []Status {
+	c.entriesMutex.RLock()
+	defer c.entriesMutex.RUnlock()
+
+	statuses := make([]Status, 0, len(c.entryKeys))
+	for _, key := range c.entryKeys {
+		statuses = append(statuses, c.entries[key].status())
+	}
+
+	return statuses
+}
+
+func (c *Cache) addNotReady(n int) {
+	c.readyMutex.Lock()
+	defer c.readyMutex.Unlock()
+
+	if n == 0 {
+		return
+	}
+
+	if c.notReady == 0 {
+		c.ready = make(chan struct{})
+	}
+	c.notReady += n
+}
+
+func (c *Cache) markReady() {
+	c.readyMutex.Lock()
+	defer c.readyMutex.Unlock()
+
+	c.notReady--
+	if c.notReady == 0 {
+		close(c.ready)
+	}
+}
+
+// closed once all registered data has been fresh at least once
+func (c *Cache) Ready() <-chan struct{} {
+	c.readyMutex.Lock()
+	defer c.readyMutex.Unlock()
+	return c.ready
+}
+
+func (c *Cache) WaitReady(ctx context.Context) error {
+	select {
+	case <-c.Ready():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// returns the cache made by the package level Init
+func Default() *Cache {
+	return defaultCache
+}
+
+func Init(db *bbolt.DB, bucket []byte, dataInterfaces []DataInterface) error {
+	c, err := New(db, bucket, Options{})
+	if err != nil {
+		return err
+	}
+
+	defaultCache = c
+
+	return c.Init(dataInterfaces)
+}
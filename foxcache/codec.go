@@ -0,0 +1,130 @@
+package foxcache
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// encodes cache data for the store. implement for cbor, msgpack, etc
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSONCodec Codec = jsonCodec{}
+	// keeps more type fidelity and is faster for large values
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var (
+	fingerprints = sync.Map{}
+
+	marshalerTypes = []reflect.Type{
+		reflect.TypeFor[json.Marshaler](),
+		reflect.TypeFor[encoding.TextMarshaler](),
+		reflect.TypeFor[gob.GobEncoder](),
+	}
+)
+
+// hash of the shape of T so changing the type invalidates old data
+func typeFingerprint[T any]() string {
+	t := reflect.TypeFor[T]()
+
+	fingerprint, ok := fingerprints.Load(t)
+	if ok {
+		return fingerprint.(string)
+	}
+
+	var sb strings.Builder
+	describeType(&sb, t, map[reflect.Type]bool{})
+
+	fingerprint = strconv.FormatUint(xxhash.Sum64String(sb.String()), 36)
+	fingerprints.Store(t, fingerprint)
+
+	return fingerprint.(string)
+}
+
+func describeType(sb *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	if t == nil {
+		sb.WriteString("nil")
+		return
+	}
+
+	// encodes itself or recursive, so go by name
+	if seen[t] {
+		sb.WriteString(t.String())
+		return
+	}
+	for _, marshaler := range marshalerTypes {
+		if t.Implements(marshaler) || reflect.PointerTo(t).Implements(marshaler) {
+			sb.WriteString(t.String())
+			return
+		}
+	}
+
+	seen[t] = true
+	defer delete(seen, t)
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		sb.WriteString("*")
+		describeType(sb, t.Elem(), seen)
+	case reflect.Slice:
+		sb.WriteString("[]")
+		describeType(sb, t.Elem(), seen)
+	case reflect.Array:
+		sb.WriteString("[" + strconv.Itoa(t.Len()) + "]")
+		describeType(sb, t.Elem(), seen)
+	case reflect.Map:
+		sb.WriteString("map[")
+		describeType(sb, t.Key(), seen)
+		sb.WriteString("]")
+		describeType(sb, t.Elem(), seen)
+	case reflect.Struct:
+		sb.WriteString("struct{")
+		for i := range t.NumField() {
+			field := t.Field(i)
+			// only exported fields get encoded
+			if !field.IsExported() {
+				continue
+			}
+			sb.WriteString(field.Name + " ")
+			describeType(sb, field.Type, seen)
+			sb.WriteString(" " + strconv.Quote(string(field.Tag)) + ";")
+		}
+		sb.WriteString("}")
+	default:
+		sb.WriteString(t.Kind().String())
+	}
+}
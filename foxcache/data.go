@@ -1,110 +1,622 @@
 package foxcache
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
-	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/robfig/cron/v3"
-	"go.etcd.io/bbolt"
 )
 
 type Data[T any] struct {
+	// "history" is reserved
 	Key      string
 	CronSpec string
-	Current  T
-	Updated  time.Time
-	// do not use. this gets fresh data. use Current
+	// instead of CronSpec. runs at a per key offset so they don't all line up
+	Every time.Duration
+	// random delay per run, up to this. must be below Every, and should be
+	// below the cron interval
+	Jitter time.Duration
+	// Deprecated: racy when read during a refresh. use Get
+	Current T
+	// Deprecated: racy when read during a refresh. use Get
+	Updated time.Time
+	// do not use. this gets fresh data. use Get
 	Retrieve func() (T, error)
+	// used instead of Retrieve if set. cancelled on timeout or shutdown.
+	// HTTPRetrieve makes conditional requests
+	RetrieveCtx func(context.Context) (T, error)
+	// overrides the cache timeout
+	Timeout time.Duration
+	// bump when T changes in a way its shape doesn't show
+	Version int
+	// retrieve again whenever one of these refreshes successfully.
+	// CronSpec is optional then
+	DependsOn []DataInterface
+
+	// when fresh data equals current, skip persisting it, update hooks and
+	// dependents. persisted expiry isn't extended then
+	Equal func(old, new T) bool
+	// like Equal but compares hashes of the json encoded data
+	SkipUnchanged bool
+
+	// rejected fresh data counts as a failed refresh
+	Validate func(old, new T) error
+	// keep this many previous values to roll back to
+	History int
+
+	Retry RetryPolicy
+	// once expired for this long without a successful refresh,
+	// Snapshot returns an error. 0 serves stale data forever
+	MaxStale time.Duration
+
+	cache    *Cache
+	schedule cron.Schedule
+	snapshot atomic.Pointer[snapshot[T]]
+
+	// serializes refreshes from cron and retries
+	refreshMutex sync.Mutex
+	retryTimer   Timer
+
+	readyOnce sync.Once
+
+	dependents  []func()
+	updateHooks []func(old, new T)
+	hooksMutex  sync.RWMutex
+
+	stats keyMetrics
+}
+
+// swapped as a whole so readers never see half of an update
+type snapshot[T any] struct {
+	value   T
+	updated time.Time
+	expires time.Time
+	lastErr error
+	// from the last http retrieve, if any
+	validators Validators
+}
+
+type Snapshot[T any] struct {
+	Value   T
+	Updated time.Time
+	Expires time.Time
+	// last failed refresh. cleared on success
+	LastErr error
+	// expired without a successful refresh since
+	Stale bool
+	// set once stale for longer than MaxStale. wraps ErrTooStale
+	Err error
+}
+
+// for the admin handler
+type Status struct {
+	Key     string
+	Updated time.Time
+	Expires time.Time
+	NextRun time.Time
+	LastErr error
+	Stale   bool
 }
 
 type DataInterface interface {
-	init(c *cron.Cron)
+	key() string
+	validate() error
+	dependencies() []DataInterface
+	// before init so dependents can be refreshed early
+	attach(c *Cache)
+	init(c *Cache)
+	// whether key in the store belongs to this
+	owns(key string) bool
+	// whether expired keys can be deleted
+	sweepable() bool
+	refresh() error
+	invalidate() error
+	status() Status
+	metrics() *keyMetrics
+	keepsHistory() bool
+	history() ([]HistoryEntry, error)
+	rollback(id string) error
 }
 
-func (data *Data[T]) getFresh() {
-	// parse cron spec so we can get an expire time
-	schedule, err := cron.ParseStandard(data.CronSpec)
-	if err != nil {
-		slog.Error("failed to parse cron spec", "err", err.Error())
-		os.Exit(1)
-		// exit program entirely
+func (data *Data[T]) key() string {
+	return data.Key
+}
+
+func (data *Data[T]) owns(key string) bool {
+	return key == data.Key
+}
+
+func (data *Data[T]) sweepable() bool {
+	return false
+}
+
+func (data *Data[T]) dependencies() []DataInterface {
+	return data.DependsOn
+}
+
+func (data *Data[T]) addDependent(refresh func()) {
+	data.hooksMutex.Lock()
+	defer data.hooksMutex.Unlock()
+	data.dependents = append(data.dependents, refresh)
+}
+
+func (data *Data[T]) expires() time.Time {
+	snapshot := data.snapshot.Load()
+	if snapshot == nil {
+		return time.Time{}
+	}
+	return snapshot.expires
+}
+
+// failed retrieves store a snapshot too, so check it was ever updated
+func (data *Data[T]) hasValue() bool {
+	snapshot := data.snapshot.Load()
+	return snapshot != nil && !snapshot.updated.IsZero()
+}
+
+// parses cron spec once so refreshes dont have to
+func (data *Data[T]) validate() error {
+	if data.Retrieve == nil && data.RetrieveCtx == nil {
+		return errors.New("missing retrieve func")
+	}
+
+	if data.Jitter < 0 {
+		return errors.New("jitter can't be negative")
+	}
+
+	switch {
+	case data.CronSpec != "" && data.Every != 0:
+		return errors.New("set either cron spec or every")
+
+	case data.Every < 0:
+		return errors.New("every can't be negative")
+
+	// runs could swap places
+	case data.Every > 0 && data.Jitter >= data.Every:
+		return errors.New("jitter must be below every")
+
+	case data.Every > 0:
+		data.schedule = newIntervalSchedule(data.Key, data.Every)
+
+	case data.CronSpec != "":
+		schedule, err := cron.ParseStandard(data.CronSpec)
+		if err != nil {
+			return fmt.Errorf("invalid cron spec %q: %w", data.CronSpec, err)
+		}
+		data.schedule = schedule
+
+	// derived data doesn't need a schedule
+	case len(data.DependsOn) > 0:
+		return nil
+
+	default:
+		return errors.New("missing cron spec or every")
+	}
+
+	if data.Jitter > 0 {
+		data.schedule = jitterSchedule{
+			base:   data.schedule,
+			jitter: data.Jitter,
+			key:    data.Key,
+		}
 	}
 
-	expires := schedule.Next(time.Now())
+	return nil
+}
+
+func (data *Data[T]) set(
+	value T, updated, expires time.Time, validators Validators,
+) {
+	data.snapshot.Store(&snapshot[T]{
+		value:      value,
+		updated:    updated,
+		expires:    expires,
+		validators: validators,
+	})
+
+	// kept for compatibility
+	data.Current = value
+	data.Updated = updated
+}
+
+// keeps the current value but records the failure
+func (data *Data[T]) setErr(err error) {
+	next := snapshot[T]{}
+	current := data.snapshot.Load()
+	if current != nil {
+		next = *current
+	}
+	next.lastErr = err
+	data.snapshot.Store(&next)
+}
+
+// returns the current value and when it was updated. safe to use concurrently
+func (data *Data[T]) Get() (T, time.Time) {
+	snapshot := data.snapshot.Load()
+	if snapshot == nil {
+		var zero T
+		return zero, time.Time{}
+	}
+	return snapshot.value, snapshot.updated
+}
+
+// like Get but also reports whether the value is stale
+func (data *Data[T]) Snapshot() Snapshot[T] {
+	current := data.snapshot.Load()
+	if current == nil {
+		current = &snapshot[T]{}
+	}
+
+	now := data.now()
+
+	snapshot := Snapshot[T]{
+		Value:   current.value,
+		Updated: current.updated,
+		Expires: current.expires,
+		LastErr: current.lastErr,
+		Stale:   now.After(current.expires),
+	}
+
+	if snapshot.Stale && data.MaxStale > 0 &&
+		now.Sub(current.expires) > data.MaxStale {
+		if current.lastErr != nil {
+			snapshot.Err = fmt.Errorf("%w: %w", ErrTooStale, current.lastErr)
+		} else {
+			snapshot.Err = ErrTooStale
+		}
+	}
+
+	return snapshot
+}
+
+func (data *Data[T]) retrieve(validators *validatorsHolder) (T, error) {
+	ctx, cancel := data.cache.retrieveContext(data.Timeout)
+	defer cancel()
+
+	if data.RetrieveCtx != nil {
+		return data.RetrieveCtx(withValidators(ctx, validators))
+	}
+
+	// cant cancel retrieve so stop waiting for it instead
+	type result struct {
+		value T
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := data.Retrieve()
+		done <- result{value, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.value, result.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+func (data *Data[T]) getFresh(attempt int) error {
+	if !data.cache.startRunning() {
+		return ErrShutdown
+	}
+	defer data.cache.running.Done()
+
+	data.refreshMutex.Lock()
+	defer data.refreshMutex.Unlock()
+
+	// a newer refresh replaces any pending retry
+	if data.retryTimer != nil {
+		data.retryTimer.Stop()
+		data.retryTimer = nil
+	}
+
+	expires := data.nextExpires()
+
+	validators := &validatorsHolder{}
+	current := data.snapshot.Load()
+	if current != nil {
+		validators.previous = current.validators
+	}
 
 	// get data
-	freshData, err := data.Retrieve()
+	start := data.cache.clock.Now()
+	freshData, err := data.retrieve(validators)
+
+	notModified := errors.Is(err, ErrNotModified)
+	if notModified {
+		err = data.extend(expires, validators.next)
+	}
+
+	data.stats.recordRetrieve(start, data.cache.clock.Now(), err)
+
+	if err == nil && !notModified && data.Validate != nil {
+		oldData, _ := data.Get()
+		err = data.Validate(oldData, freshData)
+		if err != nil {
+			err = fmt.Errorf("rejected fresh data: %w", err)
+		}
+	}
+
 	if err != nil {
 		slog.Error(
 			"failed to get data",
+			"key", data.Key, "attempt", attempt, "err", err.Error(),
+		)
+
+		data.setErr(err)
+
+		if attempt < data.Retry.Attempts && data.cache.ctx.Err() == nil {
+			data.retryTimer = data.cache.clock.AfterFunc(
+				data.Retry.backoff(data.Key, attempt, data.cache.clock.Now()),
+				func() {
+					data.getFresh(attempt + 1)
+				},
+			)
+		}
+
+		return err
+	}
+
+	if notModified {
+		return nil
+	}
+
+	data.apply(
+		freshData, data.cache.clock.Now(), expires, validators.next, true,
+	)
+
+	return nil
+}
+
+// sets, persists and notifies. expects refresh lock
+func (data *Data[T]) apply(
+	newData T, updated, expires time.Time, validators Validators, record bool,
+) {
+	var oldData T
+	previous := data.snapshot.Load()
+	if previous != nil {
+		oldData = previous.value
+	}
+
+	data.set(newData, updated, expires, validators)
+	data.readyOnce.Do(data.cache.markReady)
+
+	changed := previous == nil || previous.updated.IsZero() ||
+		!data.unchanged(oldData, newData)
+	if !changed && previous.validators == validators {
+		return
+	}
+
+	bytes, err := setCache(data.cache, data.Key, cacheData[T]{
+		Data:       newData,
+		Updated:    updated,
+		Expires:    expires,
+		Version:    data.Version,
+		Validators: validators,
+	})
+	data.stats.recordSize(len(bytes))
+	if err != nil {
+		slog.Error(
+			"failed to set cache",
 			"key", data.Key, "err", err.Error(),
 		)
+	} else if changed && record && data.History > 0 {
+		err = data.record(updated, bytes)
+		if err != nil {
+			slog.Error(
+				"failed to record history",
+				"key", data.Key, "err", err.Error(),
+			)
+		}
+	}
+
+	// only new validators
+	if !changed {
 		return
 	}
 
-	data.Current = freshData
-	data.Updated = time.Now()
+	data.hooksMutex.RLock()
+	defer data.hooksMutex.RUnlock()
+
+	for _, hook := range data.updateHooks {
+		hook(oldData, newData)
+	}
 
-	err = setCache(data.Key, cacheData[T]{
-		Data:    data.Current,
-		Updated: data.Updated,
-		Expires: expires,
+	for _, refresh := range data.dependents {
+		refresh()
+	}
+}
+
+// keeps the current value until a later expiry. expects refresh lock
+func (data *Data[T]) extend(expires time.Time, validators Validators) error {
+	current := data.snapshot.Load()
+	if current == nil || current.updated.IsZero() {
+		return errors.New("not modified but nothing cached")
+	}
+
+	data.set(current.value, current.updated, expires, validators)
+	data.readyOnce.Do(data.cache.markReady)
+
+	bytes, err := setCache(data.cache, data.Key, cacheData[T]{
+		Data:       current.value,
+		Updated:    current.updated,
+		Expires:    expires,
+		Version:    data.Version,
+		Validators: validators,
 	})
+	data.stats.recordSize(len(bytes))
 	if err != nil {
 		slog.Error(
 			"failed to set cache",
 			"key", data.Key, "err", err.Error(),
 		)
 	}
+
+	return nil
 }
 
-func (data *Data[T]) init(c *cron.Cron) {
-	// try from cache
-	cache, err := getCache[T](data.Key)
+// runs after each refresh that changed the data, in the refreshing goroutine
+func (data *Data[T]) OnUpdate(hook func(old, new T)) {
+	data.hooksMutex.Lock()
+	defer data.hooksMutex.Unlock()
+	data.updateHooks = append(data.updateHooks, hook)
+}
+
+func (data *Data[T]) unchanged(oldData, newData T) bool {
+	if data.Equal != nil {
+		return data.Equal(oldData, newData)
+	}
+
+	if !data.SkipUnchanged {
+		return false
+	}
+
+	// json sorts map keys unlike gob
+	oldBytes, err := json.Marshal(oldData)
 	if err != nil {
-		// if expires will also error
-		slog.Info("fetching fresh", "key", data.Key)
-		data.getFresh()
-	} else {
-		slog.Info("already cached", "key", data.Key)
-		data.Current = cache.Data
-		data.Updated = cache.Updated
+		return false
 	}
 
-	// setup cron
-	c.AddFunc(data.CronSpec, func() {
-		data.getFresh()
-	})
+	newBytes, err := json.Marshal(newData)
+	if err != nil {
+		return false
+	}
 
-	// slog.Println("starting cron for " + cachedData.Key)
+	return xxhash.Sum64(oldBytes) == xxhash.Sum64(newBytes)
 }
 
-func Init(db *bbolt.DB, bucket []byte, dataInterfaces []DataInterface) error {
-	currentDB = db
-	currentBucket = bucket
+// next cron run or when the first dependency expires
+func (data *Data[T]) nextExpires() time.Time {
+	var expires time.Time
+	if data.schedule != nil {
+		expires = data.schedule.Next(data.cache.clock.Now())
+	}
 
-	err := db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(bucket)
-		return err
-	})
-	if err != nil {
-		return errors.New("failed to make cache bucket: " + err.Error())
+	for _, dependency := range data.DependsOn {
+		dependencyExpires := dependency.(dependable).expires()
+		if dependencyExpires.IsZero() {
+			continue
+		}
+		if expires.IsZero() || dependencyExpires.Before(expires) {
+			expires = dependencyExpires
+		}
+	}
+
+	return expires
+}
+
+func (data *Data[T]) refresh() error {
+	return data.getFresh(0)
+}
+
+// marks current value as expired. will be refreshed on the next cron run
+func (data *Data[T]) invalidate() error {
+	next := snapshot[T]{}
+	current := data.snapshot.Load()
+	if current != nil {
+		next = *current
 	}
+	next.expires = data.cache.clock.Now()
+	data.snapshot.Store(&next)
 
-	c := cron.New()
+	return data.cache.store.Delete(data.Key)
+}
 
-	var wg sync.WaitGroup
-	for _, data := range dataInterfaces {
-		wg.Go(func() {
-			data.init(c)
+func (data *Data[T]) status() Status {
+	snapshot := data.Snapshot()
+	return Status{
+		Key:     data.Key,
+		Updated: snapshot.Updated,
+		Expires: snapshot.Expires,
+		NextRun: data.cache.scheduler.Next(data.Key),
+		LastErr: snapshot.LastErr,
+		Stale:   snapshot.Stale,
+	}
+}
+
+func (data *Data[T]) attach(c *Cache) {
+	data.cache = c
+}
+
+// works before init too
+func (data *Data[T]) now() time.Time {
+	if data.cache == nil {
+		return time.Now()
+	}
+	return data.cache.clock.Now()
+}
+
+func (data *Data[T]) metrics() *keyMetrics {
+	return &data.stats
+}
+
+func (data *Data[T]) init(c *Cache) {
+	// try from cache
+	var cache *cacheData[T]
+	bytes, err := c.store.Get(data.Key)
+	if err == nil {
+		cache, err = decodeCache[T](c, data.Key, data.Version, bytes)
+	}
+	fresh := err == nil && c.clock.Now().Before(cache.Expires)
+
+	if err == nil {
+		data.stats.recordSize(len(bytes))
+
+		// unchanged values would never be written again otherwise
+		if c.outdated(data.Key, bytes) {
+			_, err := setCache(c, data.Key, *cache)
+			if err != nil {
+				slog.Error(
+					"failed to re-encrypt cache",
+					"key", data.Key, "err", err.Error(),
+				)
+			}
+		}
+	}
+
+	if fresh {
+		slog.Info("already cached", "key", data.Key)
+		data.set(cache.Data, cache.Updated, cache.Expires, cache.Validators)
+		data.readyOnce.Do(c.markReady)
+		data.stats.recordHit()
+	} else if err == nil {
+		// serve stale until refreshed
+		data.set(cache.Data, cache.Updated, cache.Expires, cache.Validators)
+	}
+
+	// setup cron
+	if data.schedule != nil {
+		c.scheduler.Schedule(data.Key, data.schedule, func() {
+			data.getFresh(0)
 		})
 	}
-	wg.Wait()
 
-	c.Start()
+	if fresh {
+		return
+	}
 
-	return nil
+	// wait for dependencies to refresh instead of deriving from nothing
+	for _, dependency := range data.DependsOn {
+		if !dependency.(dependable).hasValue() {
+			return
+		}
+	}
+
+	slog.Info("fetching fresh", "key", data.Key)
+
+	if c.background {
+		go data.getFresh(0)
+	} else {
+		data.getFresh(0)
+	}
 }
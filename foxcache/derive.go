@@ -0,0 +1,103 @@
+package foxcache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// implemented by data that others can depend on
+type dependable interface {
+	DataInterface
+	addDependent(refresh func())
+	expires() time.Time
+	hasValue() bool
+}
+
+// groups data so each group only depends on earlier groups or data
+// registered before. errors on unregistered dependencies and cycles
+func (c *Cache) dependencyLevels(
+	dataInterfaces []DataInterface,
+) ([][]DataInterface, error) {
+	inBatch := map[DataInterface]bool{}
+	for _, data := range dataInterfaces {
+		inBatch[data] = true
+	}
+
+	c.entriesMutex.RLock()
+	defer c.entriesMutex.RUnlock()
+
+	var errs []error
+	for _, data := range dataInterfaces {
+		for _, dependency := range data.dependencies() {
+			_, ok := dependency.(dependable)
+			if !ok {
+				errs = append(errs, fmt.Errorf(
+					"%s: can't depend on %s", data.key(), dependency.key(),
+				))
+				continue
+			}
+			if !inBatch[dependency] && c.entries[dependency.key()] != dependency {
+				errs = append(errs, fmt.Errorf(
+					"%s: depends on unregistered %s", data.key(), dependency.key(),
+				))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	levels := map[DataInterface]int{}
+	visiting := map[DataInterface]bool{}
+	var path []string
+
+	var visit func(data DataInterface) (int, error)
+	visit = func(data DataInterface) (int, error) {
+		level, ok := levels[data]
+		if ok {
+			return level, nil
+		}
+
+		path = append(path, data.key())
+		defer func() { path = path[:len(path)-1] }()
+
+		if visiting[data] {
+			return 0, errors.New("dependency cycle: " + strings.Join(path, " -> "))
+		}
+		visiting[data] = true
+
+		for _, dependency := range data.dependencies() {
+			// registered before so already initialized
+			if !inBatch[dependency] {
+				continue
+			}
+
+			dependencyLevel, err := visit(dependency)
+			if err != nil {
+				return 0, err
+			}
+
+			level = max(level, dependencyLevel+1)
+		}
+
+		levels[data] = level
+		return level, nil
+	}
+
+	var grouped [][]DataInterface
+	for _, data := range dataInterfaces {
+		level, err := visit(data)
+		if err != nil {
+			return nil, err
+		}
+
+		for len(grouped) <= level {
+			grouped = append(grouped, nil)
+		}
+		grouped[level] = append(grouped[level], data)
+	}
+
+	return grouped, nil
+}
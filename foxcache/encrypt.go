@@ -0,0 +1,154 @@
+package foxcache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"slices"
+)
+
+// encrypts stored values, including history. exports stay encrypted.
+// key is the store key, authenticated so values can't be moved between keys
+type Encryption interface {
+	Seal(key string, plaintext []byte) ([]byte, error)
+	// returns ErrNotEncrypted for values that were never sealed
+	Open(key string, ciphertext []byte) ([]byte, error)
+}
+
+var ErrNotEncrypted = errors.New("value not encrypted")
+
+// marks sealed values so unencrypted ones can still be read
+var sealedPrefix = []byte("fxe1")
+
+var errDecrypt = errors.New("failed to decrypt, no matching key")
+
+// encryptions that can tell a value was sealed with an old key
+type keyRotation interface {
+	sealedWithOld(key string, ciphertext []byte) bool
+}
+
+type aesGCM struct {
+	// first seals, all open
+	aeads []cipher.AEAD
+}
+
+// keys are 16, 24 or 32 bytes. values are sealed with key and opened with
+// key or any old key, so rotate by moving the previous key to old keys.
+// data is re-encrypted with the new key by Init and memo entries when next
+// retrieved. history entries never are, so keep old keys while rolling back
+// to them matters.
+// see Options.ReadUnencrypted for turning encryption on later
+func NewAESGCM(key []byte, oldKeys ...[]byte) (Encryption, error) {
+	encryption := &aesGCM{}
+
+	for _, key := range append([][]byte{key}, oldKeys...) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		encryption.aeads = append(encryption.aeads, aead)
+	}
+
+	return encryption, nil
+}
+
+// prefix, nonce then ciphertext
+func (encryption *aesGCM) Seal(key string, plaintext []byte) ([]byte, error) {
+	aead := encryption.aeads[0]
+
+	out := make(
+		[]byte, len(sealedPrefix)+aead.NonceSize(),
+		len(sealedPrefix)+aead.NonceSize()+len(plaintext)+aead.Overhead(),
+	)
+	copy(out, sealedPrefix)
+
+	nonce := out[len(sealedPrefix):]
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(out, nonce, plaintext, additionalData(key)), nil
+}
+
+func (encryption *aesGCM) Open(key string, ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, sealedPrefix) {
+		return nil, ErrNotEncrypted
+	}
+	ciphertext = ciphertext[len(sealedPrefix):]
+
+	for _, aead := range encryption.aeads {
+		if len(ciphertext) < aead.NonceSize() {
+			return nil, errDecrypt
+		}
+
+		nonce := ciphertext[:aead.NonceSize()]
+		plaintext, err := aead.Open(
+			nil, nonce, ciphertext[aead.NonceSize():], additionalData(key),
+		)
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+
+	return nil, errDecrypt
+}
+
+func (encryption *aesGCM) sealedWithOld(key string, ciphertext []byte) bool {
+	if len(encryption.aeads) == 1 {
+		return false
+	}
+
+	current := &aesGCM{aeads: encryption.aeads[:1]}
+	_, err := current.Open(key, ciphertext)
+	return errors.Is(err, errDecrypt)
+}
+
+// prefix then store key
+func additionalData(key string) []byte {
+	return append(slices.Clip(sealedPrefix), key...)
+}
+
+func (c *Cache) seal(key string, plaintext []byte) ([]byte, error) {
+	if c.encryption == nil {
+		return plaintext, nil
+	}
+	return c.encryption.Seal(key, plaintext)
+}
+
+func (c *Cache) open(key string, ciphertext []byte) ([]byte, error) {
+	if c.encryption == nil {
+		return ciphertext, nil
+	}
+
+	plaintext, err := c.encryption.Open(key, ciphertext)
+	if errors.Is(err, ErrNotEncrypted) && c.unencrypted {
+		return ciphertext, nil
+	}
+
+	return plaintext, err
+}
+
+// whether a readable value should be sealed again, since it wasn't sealed
+// or was sealed with an old key
+func (c *Cache) outdated(key string, stored []byte) bool {
+	if c.encryption == nil {
+		return false
+	}
+
+	rotation, ok := c.encryption.(keyRotation)
+	if ok && rotation.sealedWithOld(key, stored) {
+		return true
+	}
+
+	_, err := c.encryption.Open(key, stored)
+	return errors.Is(err, ErrNotEncrypted)
+}
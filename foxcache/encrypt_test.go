@@ -0,0 +1,156 @@
+package foxcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestAESGCM(t *testing.T, keys ...string) Encryption {
+	t.Helper()
+
+	var oldKeys [][]byte
+	for _, key := range keys[1:] {
+		oldKeys = append(oldKeys, []byte(key))
+	}
+
+	encryption, err := NewAESGCM([]byte(keys[0]), oldKeys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encryption
+}
+
+func TestAESGCM(t *testing.T) {
+	oldKey := "0123456789abcdef"
+	newKey := "fedcba9876543210"
+
+	old := newTestAESGCM(t, oldKey)
+	sealed, err := old.Seal("a", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("hello")) {
+		t.Error("sealed value contains the plaintext")
+	}
+
+	plaintext, err := old.Open("a", sealed)
+	if err != nil || string(plaintext) != "hello" {
+		t.Fatalf("got %q, %v", plaintext, err)
+	}
+
+	// bound to the store key
+	_, err = old.Open("b", sealed)
+	if err == nil {
+		t.Error("opened a value moved to another key")
+	}
+
+	rotated := newTestAESGCM(t, newKey, oldKey)
+	plaintext, err = rotated.Open("a", sealed)
+	if err != nil || string(plaintext) != "hello" {
+		t.Fatalf("with old key got %q, %v", plaintext, err)
+	}
+	if !rotated.(keyRotation).sealedWithOld("a", sealed) {
+		t.Error("expected value sealed with the old key")
+	}
+
+	_, err = newTestAESGCM(t, newKey).Open("a", sealed)
+	if err == nil {
+		t.Error("opened without the old key")
+	}
+
+	_, err = old.Open("a", []byte(`{"data":1}`))
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("expected ErrNotEncrypted, got %v", err)
+	}
+}
+
+// inits data over store and returns how often it was retrieved
+func initTestData(t *testing.T, store Store, opts Options) int {
+	t.Helper()
+
+	calls := 0
+	data := &Data[int]{
+		Key:   "a",
+		Every: time.Hour,
+		Retrieve: func() (int, error) {
+			calls++
+			return 1, nil
+		},
+	}
+
+	c := NewWithStore(store, opts)
+	err := c.Init([]DataInterface{data})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return calls
+}
+
+func TestReadUnencrypted(t *testing.T) {
+	encryption := newTestAESGCM(t, "0123456789abcdef")
+
+	for _, readUnencrypted := range []bool{false, true} {
+		store := NewMemoryStore()
+		initTestData(t, store, Options{})
+
+		calls := initTestData(t, store, Options{
+			Encryption:      encryption,
+			ReadUnencrypted: readUnencrypted,
+		})
+
+		// without it the plaintext value is a miss
+		wantCalls := 1
+		if readUnencrypted {
+			wantCalls = 0
+		}
+		if calls != wantCalls {
+			t.Errorf(
+				"read unencrypted %v: got %d calls, want %d",
+				readUnencrypted, calls, wantCalls,
+			)
+		}
+
+		stored, err := store.Get("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = encryption.Open("a", stored)
+		if err != nil {
+			t.Errorf("read unencrypted %v: stored value %v", readUnencrypted, err)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := "0123456789abcdef"
+	newKey := "fedcba9876543210"
+
+	store := NewMemoryStore()
+	initTestData(t, store, Options{Encryption: newTestAESGCM(t, oldKey)})
+
+	// still fresh, so only init can move it to the new key
+	calls := initTestData(t, store, Options{
+		Encryption: newTestAESGCM(t, newKey, oldKey),
+	})
+	if calls != 0 {
+		t.Fatalf("got %d calls, expected the cached value", calls)
+	}
+
+	stored, err := store.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newTestAESGCM(t, newKey).Open("a", stored)
+	if err != nil {
+		t.Errorf("stored value not sealed with the new key: %v", err)
+	}
+}
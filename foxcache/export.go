@@ -0,0 +1,114 @@
+package foxcache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+)
+
+const defaultSeedFile = "cache.jsonl"
+
+// one line of an export. data is kept readable when it's json
+type exportLine struct {
+	Key   string          `json:"key"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Bytes []byte          `json:"bytes,omitempty"`
+}
+
+// writes every stored key as json lines. history isn't included
+func (c *Cache) Export(w io.Writer) error {
+	keys, err := c.store.List()
+	if err != nil {
+		return err
+	}
+	slices.Sort(keys)
+
+	encoder := json.NewEncoder(w)
+
+	for _, key := range keys {
+		bytes, err := c.store.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		line := exportLine{Key: key}
+		if json.Valid(bytes) {
+			line.Data = bytes
+		} else {
+			line.Bytes = bytes
+		}
+
+		err = encoder.Encode(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stores every line from Export, overwriting existing keys. entries already
+// initialized keep their values until refreshed
+func (c *Cache) Import(r io.Reader) error {
+	return c.importLines(r, true)
+}
+
+func (c *Cache) importLines(r io.Reader, overwrite bool) error {
+	existing := map[string]bool{}
+	if !overwrite {
+		keys, err := c.store.List()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			existing[key] = true
+		}
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(r))
+
+	for {
+		var line exportLine
+		err := decoder.Decode(&line)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if line.Key == "" || existing[line.Key] {
+			continue
+		}
+
+		bytes := line.Bytes
+		if len(line.Data) > 0 {
+			bytes = line.Data
+		}
+
+		err = c.store.Put(line.Key, bytes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", line.Key, err)
+		}
+	}
+}
+
+// stores keys from an Export file that aren't stored yet
+func (c *Cache) seed(fsys fs.FS, name string) error {
+	if name == "" {
+		name = defaultSeedFile
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return c.importLines(file, false)
+}
@@ -0,0 +1,197 @@
+package foxcache
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	errNoHistory = errors.New("no history kept")
+)
+
+type HistoryEntry struct {
+	ID      string
+	Updated time.Time
+	Expires time.Time
+}
+
+// sub store holding the history of all data, as key/id. bolt keeps subs
+// with the keys so it's reserved
+const historyName = "history"
+
+func (c *Cache) historyStore() (Store, error) {
+	return c.store.Sub(historyName)
+}
+
+// ids of key, oldest first
+func historyIDs(store Store, key string) ([]string, error) {
+	keys, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, historyKey := range keys {
+		owner, id := splitHistoryKey(historyKey)
+		if owner == key {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+// ids have no slash but data keys can
+func splitHistoryKey(historyKey string) (key string, id string) {
+	i := strings.LastIndexByte(historyKey, '/')
+	if i < 0 {
+		return "", historyKey
+	}
+	return historyKey[:i], historyKey[i+1:]
+}
+
+// ids sort oldest first
+func historyID(updated time.Time) string {
+	return fmt.Sprintf("%020d", updated.UnixNano())
+}
+
+// stores encoded cache data and drops the oldest past the limit
+func (data *Data[T]) record(updated time.Time, bytes []byte) error {
+	store, err := data.cache.historyStore()
+	if err != nil {
+		return err
+	}
+
+	err = store.Put(data.Key+"/"+historyID(updated), bytes)
+	if err != nil {
+		return err
+	}
+
+	ids, err := historyIDs(store, data.Key)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for len(ids) > data.History {
+		errs = append(errs, store.Delete(data.Key+"/"+ids[0]))
+		ids = ids[1:]
+	}
+
+	return errors.Join(errs...)
+}
+
+// newest first
+func (data *Data[T]) history() ([]HistoryEntry, error) {
+	if data.History <= 0 {
+		return nil, nil
+	}
+
+	store, err := data.cache.historyStore()
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := historyIDs(store, data.Key)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(ids)
+
+	entries := make([]HistoryEntry, 0, len(ids))
+	for _, id := range ids {
+		bytes, err := store.Get(data.Key + "/" + id)
+		if err != nil {
+			continue
+		}
+
+		// skip what can't be rolled back to
+		cache, err := decodeCache[T](data.cache, data.Key, data.Version, bytes)
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, HistoryEntry{
+			ID:      id,
+			Updated: cache.Updated,
+			Expires: cache.Expires,
+		})
+	}
+
+	return entries, nil
+}
+
+// replaces current data with an earlier value until the next refresh
+func (data *Data[T]) rollback(id string) error {
+	if data.History <= 0 {
+		return errNoHistory
+	}
+	// would be another key's history
+	if strings.Contains(id, "/") {
+		return ErrNotFound
+	}
+
+	store, err := data.cache.historyStore()
+	if err != nil {
+		return err
+	}
+
+	bytes, err := store.Get(data.Key + "/" + id)
+	if err != nil {
+		return err
+	}
+
+	// sealed for the data key when first stored
+	cache, err := decodeCache[T](data.cache, data.Key, data.Version, bytes)
+	if err != nil {
+		return err
+	}
+
+	data.refreshMutex.Lock()
+	defer data.refreshMutex.Unlock()
+
+	// validators belong to the newer data
+	data.apply(
+		cache.Data, cache.Updated, data.nextExpires(), Validators{}, false,
+	)
+
+	return nil
+}
+
+func (data *Data[T]) keepsHistory() bool {
+	return data.History > 0
+}
+
+func (memo *Memo[K, V]) keepsHistory() bool {
+	return false
+}
+
+func (memo *Memo[K, V]) history() ([]HistoryEntry, error) {
+	return nil, nil
+}
+
+func (memo *Memo[K, V]) rollback(id string) error {
+	return errNoHistory
+}
+
+// previous values of key, newest first
+func (c *Cache) History(key string) ([]HistoryEntry, error) {
+	data, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return data.history()
+}
+
+// replaces current data with a value from History until the next refresh
+func (c *Cache) Rollback(key string, id string) error {
+	data, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	return data.rollback(id)
+}
@@ -0,0 +1,128 @@
+package foxcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// return from retrieve when upstream has nothing new. the current value is
+// kept and only its expiry is extended
+var ErrNotModified = errors.New("not modified")
+
+// http response validators stored next to the data
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+type validatorsKey struct{}
+
+// previous is what was stored, next is what the retrieve got back
+type validatorsHolder struct {
+	previous Validators
+	next     Validators
+}
+
+func withValidators(
+	ctx context.Context, holder *validatorsHolder,
+) context.Context {
+	return context.WithValue(ctx, validatorsKey{}, holder)
+}
+
+// nil outside of a Data retrieve
+func validatorsFrom(ctx context.Context) *validatorsHolder {
+	holder, _ := ctx.Value(validatorsKey{}).(*validatorsHolder)
+	return holder
+}
+
+// use as RetrieveCtx. sends conditional requests with the stored validators
+// and returns ErrNotModified on 304. decode reads 200 responses
+func HTTPRetrieve[T any](
+	client *http.Client,
+	newRequest func(ctx context.Context) (*http.Request, error),
+	decode func(res *http.Response) (T, error),
+) func(ctx context.Context) (T, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return func(ctx context.Context) (T, error) {
+		var zero T
+
+		req, err := newRequest(ctx)
+		if err != nil {
+			return zero, err
+		}
+
+		holder := validatorsFrom(ctx)
+		if holder != nil {
+			if holder.previous.ETag != "" {
+				req.Header.Set("If-None-Match", holder.previous.ETag)
+			}
+			if holder.previous.LastModified != "" {
+				req.Header.Set("If-Modified-Since", holder.previous.LastModified)
+			}
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return zero, err
+		}
+		defer res.Body.Close()
+
+		validators := Validators{
+			ETag:         res.Header.Get("ETag"),
+			LastModified: res.Header.Get("Last-Modified"),
+		}
+
+		switch {
+		case res.StatusCode == http.StatusNotModified:
+			if holder == nil {
+				return zero, ErrNotModified
+			}
+			// 304s may leave out unchanged validators
+			holder.next = holder.previous
+			if validators.ETag != "" {
+				holder.next.ETag = validators.ETag
+			}
+			if validators.LastModified != "" {
+				holder.next.LastModified = validators.LastModified
+			}
+			return zero, ErrNotModified
+
+		case res.StatusCode < 200 || res.StatusCode > 299:
+			io.Copy(io.Discard, res.Body)
+			return zero, fmt.Errorf("unexpected status: %s", res.Status)
+		}
+
+		value, err := decode(res)
+		if err != nil {
+			return zero, err
+		}
+
+		if holder != nil {
+			holder.next = validators
+		}
+
+		return value, nil
+	}
+}
+
+// HTTPRetrieve that gets url and decodes the json body
+func HTTPRetrieveJSON[T any](url string) func(ctx context.Context) (T, error) {
+	return HTTPRetrieve(
+		nil,
+		func(ctx context.Context) (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		},
+		func(res *http.Response) (T, error) {
+			var value T
+			err := json.NewDecoder(res.Body).Decode(&value)
+			return value, err
+		},
+	)
+}
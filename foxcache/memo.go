@@ -0,0 +1,413 @@
+package foxcache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lazily fetched values per key, like an oembed per url. concurrent gets for
+// the same key share one retrieve. register with Init like Data
+type Memo[K comparable, V any] struct {
+	// persisted as Key + "/" + fmt.Sprint(key), so keys that print the same
+	// share a value. use strings, numbers or types with a unique String
+	Key string
+	TTL time.Duration
+	// do not use. this gets fresh data. use Get
+	Retrieve func(ctx context.Context, key K) (V, error)
+	// overrides the cache timeout
+	Timeout time.Duration
+	// bump when V changes in a way its shape doesn't show
+	Version int
+
+	// least recently used get evicted past these. 0 is unbounded.
+	// entries persisted by earlier runs count too
+	MaxEntries int
+	// counts encoded size
+	MaxBytes int
+
+	cache *Cache
+
+	// front is most recently used
+	lru     *list.List
+	entries map[K]*list.Element
+	// persisted by earlier runs and not loaded yet, by store key
+	cold    map[string]*list.Element
+	bytes   int
+	updated time.Time
+	lastErr error
+	mutex   sync.Mutex
+
+	flights flightGroup[K, V]
+
+	stats keyMetrics
+}
+
+type memoEntry[K comparable, V any] struct {
+	key      K
+	storeKey string
+	data     cacheData[V]
+	size     int
+	// only the store key is known for cold entries
+	cold bool
+}
+
+func (memo *Memo[K, V]) key() string {
+	return memo.Key
+}
+
+func (memo *Memo[K, V]) owns(key string) bool {
+	return strings.HasPrefix(key, memo.Key+"/")
+}
+
+func (memo *Memo[K, V]) sweepable() bool {
+	return true
+}
+
+func (memo *Memo[K, V]) storeKey(key K) string {
+	return memo.Key + "/" + fmt.Sprint(key)
+}
+
+func (memo *Memo[K, V]) validate() error {
+	if memo.Retrieve == nil {
+		return errors.New("missing retrieve func")
+	}
+	if memo.TTL <= 0 {
+		return errors.New("ttl must be positive")
+	}
+	return nil
+}
+
+func (memo *Memo[K, V]) dependencies() []DataInterface {
+	return nil
+}
+
+func (memo *Memo[K, V]) attach(c *Cache) {
+	memo.cache = c
+
+	memo.mutex.Lock()
+	memo.lru = list.New()
+	memo.entries = map[K]*list.Element{}
+	memo.cold = map[string]*list.Element{}
+	memo.mutex.Unlock()
+}
+
+func (memo *Memo[K, V]) init(c *Cache) {
+	err := memo.loadCold()
+	if err != nil {
+		slog.Error(
+			"failed to load persisted memo entries",
+			"key", memo.Key, "err", err.Error(),
+		)
+	}
+
+	// nothing to wait for
+	c.markReady()
+}
+
+// counts entries from earlier runs towards the bounds. when they were last
+// used isn't known, so they go behind loaded entries in store order
+func (memo *Memo[K, V]) loadCold() error {
+	keys, err := memo.cache.store.List()
+	if err != nil {
+		return err
+	}
+
+	memo.mutex.Lock()
+	defer memo.mutex.Unlock()
+
+	for _, key := range keys {
+		_, loaded := memo.cold[key]
+		if !memo.owns(key) || loaded {
+			continue
+		}
+
+		bytes, err := memo.cache.store.Get(key)
+		if err != nil {
+			continue
+		}
+
+		memo.cold[key] = memo.lru.PushBack(&memoEntry[K, V]{
+			storeKey: key,
+			size:     len(bytes),
+			cold:     true,
+		})
+		memo.bytes += len(bytes)
+	}
+
+	memo.trim()
+
+	return nil
+}
+
+// returns cached value for key or retrieves it
+func (memo *Memo[K, V]) Get(ctx context.Context, key K) (V, error) {
+	if memo.cache == nil {
+		var zero V
+		return zero, errors.New("memo not registered")
+	}
+
+	value, ok := memo.getMemory(key)
+	if ok {
+		memo.stats.recordHit()
+		return value, nil
+	}
+
+	flight := memo.flights.do(key, func() (V, error) {
+		return memo.getFresh(key)
+	})
+
+	select {
+	case <-flight.done:
+		return flight.value, flight.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+func (memo *Memo[K, V]) getMemory(key K) (V, bool) {
+	memo.mutex.Lock()
+	defer memo.mutex.Unlock()
+
+	element, ok := memo.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	entry := element.Value.(*memoEntry[K, V])
+	if memo.cache.clock.Now().After(entry.data.Expires) {
+		var zero V
+		return zero, false
+	}
+
+	memo.lru.MoveToFront(element)
+	return entry.data.Data, true
+}
+
+// tries the store first. runs once per key at a time
+func (memo *Memo[K, V]) getFresh(key K) (V, error) {
+	storeKey := memo.storeKey(key)
+
+	cache, err := getCache[V](memo.cache, storeKey, memo.Version)
+	if err == nil && memo.cache.clock.Now().Before(cache.Expires) {
+		bytes, _ := encodeCache(memo.cache, storeKey, *cache)
+		memo.add(key, *cache, len(bytes))
+		memo.stats.recordHit()
+		return cache.Data, nil
+	}
+
+	if !memo.cache.startRunning() {
+		var zero V
+		return zero, ErrShutdown
+	}
+	defer memo.cache.running.Done()
+
+	ctx, cancel := memo.cache.retrieveContext(memo.Timeout)
+	defer cancel()
+
+	start := memo.cache.clock.Now()
+	value, err := memo.Retrieve(ctx, key)
+	memo.stats.recordRetrieve(start, memo.cache.clock.Now(), err)
+	if err != nil {
+		slog.Error(
+			"failed to get data",
+			"key", storeKey, "err", err.Error(),
+		)
+
+		memo.mutex.Lock()
+		memo.lastErr = err
+		memo.mutex.Unlock()
+
+		var zero V
+		return zero, err
+	}
+
+	now := memo.cache.clock.Now()
+	data := cacheData[V]{
+		Data:    value,
+		Updated: now,
+		Expires: now.Add(memo.TTL),
+		Version: memo.Version,
+	}
+
+	bytes, err := encodeCache(memo.cache, storeKey, data)
+	if err == nil {
+		err = memo.cache.store.Put(storeKey, bytes)
+	}
+	if err != nil {
+		slog.Error(
+			"failed to set cache",
+			"key", storeKey, "err", err.Error(),
+		)
+	}
+
+	memo.mutex.Lock()
+	memo.lastErr = nil
+	memo.updated = now
+	memo.mutex.Unlock()
+
+	memo.add(key, data, len(bytes))
+
+	return value, nil
+}
+
+func (memo *Memo[K, V]) add(key K, data cacheData[V], size int) {
+	memo.mutex.Lock()
+	defer memo.mutex.Unlock()
+
+	// loaded now so its key is known
+	storeKey := memo.storeKey(key)
+	coldElement, ok := memo.cold[storeKey]
+	if ok {
+		memo.lru.Remove(coldElement)
+		delete(memo.cold, storeKey)
+		memo.bytes -= coldElement.Value.(*memoEntry[K, V]).size
+	}
+
+	element, ok := memo.entries[key]
+	if ok {
+		entry := element.Value.(*memoEntry[K, V])
+		memo.bytes += size - entry.size
+		entry.data = data
+		entry.size = size
+		memo.lru.MoveToFront(element)
+	} else {
+		memo.entries[key] = memo.lru.PushFront(&memoEntry[K, V]{
+			key:      key,
+			storeKey: storeKey,
+			data:     data,
+			size:     size,
+		})
+		memo.bytes += size
+	}
+
+	memo.trim()
+}
+
+// evicts past the bounds. expects lock
+func (memo *Memo[K, V]) trim() {
+	// always keep the newest
+	for memo.lru.Len() > 1 &&
+		(memo.MaxEntries > 0 && memo.lru.Len() > memo.MaxEntries ||
+			memo.MaxBytes > 0 && memo.bytes > memo.MaxBytes) {
+		memo.evict(memo.lru.Back())
+	}
+
+	memo.stats.recordSize(memo.bytes)
+}
+
+// expects lock
+func (memo *Memo[K, V]) evict(element *list.Element) {
+	entry := element.Value.(*memoEntry[K, V])
+
+	memo.lru.Remove(element)
+	if entry.cold {
+		delete(memo.cold, entry.storeKey)
+	} else {
+		delete(memo.entries, entry.key)
+	}
+	memo.bytes -= entry.size
+
+	err := memo.cache.store.Delete(entry.storeKey)
+	if err != nil {
+		slog.Error(
+			"failed to delete cache",
+			"key", entry.storeKey, "err", err.Error(),
+		)
+	}
+}
+
+// entries are fetched on demand so refreshing drops them all
+func (memo *Memo[K, V]) refresh() error {
+	return memo.invalidate()
+}
+
+func (memo *Memo[K, V]) invalidate() error {
+	memo.mutex.Lock()
+	defer memo.mutex.Unlock()
+
+	memo.lru.Init()
+	clear(memo.entries)
+	clear(memo.cold)
+	memo.bytes = 0
+	memo.stats.recordSize(0)
+
+	// includes entries persisted by previous runs
+	keys, err := memo.cache.store.List()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, key := range keys {
+		if memo.owns(key) {
+			errs = append(errs, memo.cache.store.Delete(key))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (memo *Memo[K, V]) metrics() *keyMetrics {
+	return &memo.stats
+}
+
+func (memo *Memo[K, V]) status() Status {
+	memo.mutex.Lock()
+	defer memo.mutex.Unlock()
+
+	return Status{
+		Key:     memo.Key,
+		Updated: memo.updated,
+		LastErr: memo.lastErr,
+	}
+}
+
+// dedupes concurrent calls with the same key
+type flightGroup[K comparable, V any] struct {
+	calls map[K]*flight[V]
+	mutex sync.Mutex
+}
+
+type flight[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+func (group *flightGroup[K, V]) do(key K, fn func() (V, error)) *flight[V] {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	current, ok := group.calls[key]
+	if ok {
+		return current
+	}
+
+	if group.calls == nil {
+		group.calls = map[K]*flight[V]{}
+	}
+
+	current = &flight[V]{done: make(chan struct{})}
+	group.calls[key] = current
+
+	// not tied to any one caller so they can give up on their own
+	go func() {
+		current.value, current.err = fn()
+
+		group.mutex.Lock()
+		delete(group.calls, key)
+		group.mutex.Unlock()
+
+		close(current.done)
+	}()
+
+	return current
+}
@@ -0,0 +1,162 @@
+package foxcache
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type keyMetrics struct {
+	successes     uint64
+	failures      uint64
+	durationSum   time.Duration
+	durationCount uint64
+	lastSuccess   time.Time
+	sizeBytes     int
+	hits          uint64
+	mutex         sync.Mutex
+}
+
+func (metrics *keyMetrics) recordRetrieve(start, end time.Time, err error) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.durationSum += end.Sub(start)
+	metrics.durationCount++
+
+	if err != nil {
+		metrics.failures++
+	} else {
+		metrics.successes++
+		metrics.lastSuccess = end
+	}
+}
+
+func (metrics *keyMetrics) recordSize(size int) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.sizeBytes = size
+}
+
+func (metrics *keyMetrics) recordHit() {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.hits++
+}
+
+func (metrics *keyMetrics) copy() keyMetrics {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	return keyMetrics{
+		successes:     metrics.successes,
+		failures:      metrics.failures,
+		durationSum:   metrics.durationSum,
+		durationCount: metrics.durationCount,
+		lastSuccess:   metrics.lastSuccess,
+		sizeBytes:     metrics.sizeBytes,
+		hits:          metrics.hits,
+	}
+}
+
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// prometheus text format
+func (c *Cache) WriteMetrics(w io.Writer) error {
+	c.entriesMutex.RLock()
+	entries := make([]DataInterface, 0, len(c.entryKeys))
+	for _, key := range c.entryKeys {
+		entries = append(entries, c.entries[key])
+	}
+	c.entriesMutex.RUnlock()
+
+	type sample struct {
+		labels string
+		value  float64
+	}
+
+	type metric struct {
+		name    string
+		kind    string
+		help    string
+		samples []sample
+	}
+
+	metrics := []*metric{
+		{name: "foxcache_retrieve_duration_seconds", kind: "summary", help: "Time spent retrieving fresh data."},
+		{name: "foxcache_retrieve_success_total", kind: "counter", help: "Successful retrieves."},
+		{name: "foxcache_retrieve_failure_total", kind: "counter", help: "Failed retrieves."},
+		{name: "foxcache_last_success_timestamp_seconds", kind: "gauge", help: "Last successful retrieve. 0 if never."},
+		{name: "foxcache_expires_timestamp_seconds", kind: "gauge", help: "When the current value expires."},
+		{name: "foxcache_stale", kind: "gauge", help: "1 if the current value expired without a successful refresh."},
+		{name: "foxcache_value_size_bytes", kind: "gauge", help: "Encoded size of the current value."},
+		{name: "foxcache_cache_hits_total", kind: "counter", help: "Data fresh in the store at startup, or memo gets served without retrieving."},
+	}
+
+	for _, data := range entries {
+		stats := data.metrics().copy()
+		status := data.status()
+
+		labels := `key="` + metricsLabelEscaper.Replace(status.Key) + `"`
+
+		stale := 0.0
+		if status.Stale {
+			stale = 1
+		}
+
+		metrics[0].samples = append(metrics[0].samples,
+			sample{"_sum{" + labels + "}", stats.durationSum.Seconds()},
+			sample{"_count{" + labels + "}", float64(stats.durationCount)},
+		)
+
+		for i, value := range []float64{
+			float64(stats.successes),
+			float64(stats.failures),
+			unixSeconds(stats.lastSuccess),
+			unixSeconds(status.Expires),
+			stale,
+			float64(stats.sizeBytes),
+			float64(stats.hits),
+		} {
+			metrics[i+1].samples = append(
+				metrics[i+1].samples, sample{"{" + labels + "}", value},
+			)
+		}
+	}
+
+	var sb strings.Builder
+	for _, metric := range metrics {
+		fmt.Fprintf(&sb, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", metric.name, metric.kind)
+		for _, sample := range metric.samples {
+			fmt.Fprintf(&sb, "%s%s %g\n", metric.name, sample.labels, sample.value)
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// example usage: `http.Handle("GET /metrics", cache.MetricsHandler())`
+func (c *Cache) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+
+		err := c.WriteMetrics(w)
+		if err != nil {
+			slog.Error("failed to write cache metrics", "err", err.Error())
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package foxcache
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+var (
+	// wrapped in Snapshot.Err once data has been stale for too long
+	ErrTooStale = errors.New("cache data too stale")
+)
+
+const (
+	defaultMinBackoff = 10 * time.Second
+	defaultMaxBackoff = 10 * time.Minute
+)
+
+// retries a failed refresh until it succeeds or runs out of attempts.
+// the last good value keeps being served meanwhile
+type RetryPolicy struct {
+	// retries after a failed refresh. 0 waits for the next cron run
+	Attempts int
+	// doubled each attempt. defaults to 10 seconds
+	MinBackoff time.Duration
+	// defaults to 10 minutes
+	MaxBackoff time.Duration
+}
+
+// exponential backoff with jitter between half and the full duration.
+// the jitter is derived from the key, attempt and failure time like
+// jitterSchedule, so it's the same under a fake clock
+func (policy *RetryPolicy) backoff(
+	key string, attempt int, failed time.Time,
+) time.Duration {
+	minBackoff := policy.MinBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	backoff := minBackoff
+	for range attempt {
+		backoff *= 2
+		if backoff >= maxBackoff {
+			backoff = maxBackoff
+			break
+		}
+	}
+
+	half := backoff / 2
+	hash := xxhash.Sum64String(
+		key + "@" + strconv.Itoa(attempt) + "@" +
+			strconv.FormatInt(failed.UnixNano(), 10),
+	)
+	return half + time.Duration(hash%uint64(half+1))
+}
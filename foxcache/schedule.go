@@ -0,0 +1,117 @@
+package foxcache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/robfig/cron/v3"
+)
+
+// runs every interval at a per key offset, so entries with the same
+// interval don't all run at once
+type intervalSchedule struct {
+	every  time.Duration
+	offset time.Duration
+}
+
+func newIntervalSchedule(key string, every time.Duration) intervalSchedule {
+	return intervalSchedule{
+		every:  every,
+		offset: time.Duration(xxhash.Sum64String(key) % uint64(every)),
+	}
+}
+
+func (schedule intervalSchedule) Next(t time.Time) time.Time {
+	every := int64(schedule.every)
+	offset := int64(schedule.offset)
+
+	slot := (t.UnixNano() - offset) / every
+	return time.Unix(0, (slot+1)*every+offset).In(t.Location())
+}
+
+// delays each run of base by up to jitter. the delay is derived from the key
+// and run time, so calling Next again gives the same time, which keeps the
+// persisted expiry equal to when cron actually runs
+type jitterSchedule struct {
+	base   cron.Schedule
+	jitter time.Duration
+	key    string
+}
+
+func (schedule jitterSchedule) delay(run time.Time) time.Duration {
+	hash := xxhash.Sum64String(
+		schedule.key + "@" + strconv.FormatInt(run.UnixNano(), 10),
+	)
+	return time.Duration(hash % uint64(schedule.jitter))
+}
+
+func (schedule jitterSchedule) Next(t time.Time) time.Time {
+	// an earlier run might not have happened yet because of its delay
+	run := schedule.base.Next(t.Add(-schedule.jitter))
+	for {
+		// like cron, zero when there's no next run
+		if run.IsZero() {
+			return run
+		}
+
+		next := run.Add(schedule.delay(run))
+		if next.After(t) {
+			return next
+		}
+		run = schedule.base.Next(run)
+	}
+}
+
+// runs jobs on their schedules. defaults to cron
+type Scheduler interface {
+	Schedule(key string, schedule cron.Schedule, job func())
+	// zero if not scheduled or not started
+	Next(key string) time.Time
+	Start()
+	// done once running jobs have returned
+	Stop() context.Context
+}
+
+type cronScheduler struct {
+	cron  *cron.Cron
+	ids   map[string]cron.EntryID
+	mutex sync.RWMutex
+}
+
+func newCronScheduler(opts ...cron.Option) *cronScheduler {
+	return &cronScheduler{
+		cron: cron.New(opts...),
+		ids:  map[string]cron.EntryID{},
+	}
+}
+
+func (scheduler *cronScheduler) Schedule(
+	key string, schedule cron.Schedule, job func(),
+) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.ids[key] = scheduler.cron.Schedule(schedule, cron.FuncJob(job))
+}
+
+func (scheduler *cronScheduler) Next(key string) time.Time {
+	scheduler.mutex.RLock()
+	id, ok := scheduler.ids[key]
+	scheduler.mutex.RUnlock()
+
+	if !ok {
+		return time.Time{}
+	}
+
+	return scheduler.cron.Entry(id).Next
+}
+
+func (scheduler *cronScheduler) Start() {
+	scheduler.cron.Start()
+}
+
+func (scheduler *cronScheduler) Stop() context.Context {
+	return scheduler.cron.Stop()
+}
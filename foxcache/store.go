@@ -0,0 +1,33 @@
+package foxcache
+
+import "errors"
+
+var (
+	ErrNotFound = errors.New("key not found")
+)
+
+// where cache data gets persisted. values are encoded cache data
+type Store interface {
+	// returns ErrNotFound if missing
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	// deleting a missing key is not an error
+	Delete(key string) error
+	List() ([]string, error)
+	// separate namespace, created if missing. not included in List
+	Sub(name string) (Store, error)
+}
+
+// stores that can reclaim space from deleted keys
+type Compactor interface {
+	Compact() (CompactStats, error)
+}
+
+type CompactStats struct {
+	SizeBefore int64
+	SizeAfter  int64
+}
+
+func (stats CompactStats) Reclaimed() int64 {
+	return stats.SizeBefore - stats.SizeAfter
+}
@@ -0,0 +1,282 @@
+package foxcache
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+type BoltStore struct {
+	handle *boltHandle
+	// nested buckets from the root
+	path [][]byte
+}
+
+var errSharedBolt = errors.New(
+	"db wasn't opened by the store, use CompactTo",
+)
+
+// shared with sub stores. compact swaps db
+type boltHandle struct {
+	db    *bbolt.DB
+	mutex sync.RWMutex
+	// opened by OpenBoltStore, so compact may close it
+	owned   bool
+	options *bbolt.Options
+}
+
+// creates bucket if it doesn't exist
+func NewBoltStore(db *bbolt.DB, bucket []byte) (*BoltStore, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("failed to make cache bucket: " + err.Error())
+	}
+
+	return &BoltStore{
+		handle: &boltHandle{db: db},
+		path:   [][]byte{bucket},
+	}, nil
+}
+
+// opens the db at path for the store alone, so Compact can swap the file
+func OpenBoltStore(
+	path string, bucket []byte, options *bbolt.Options,
+) (*BoltStore, error) {
+	db, err := bbolt.Open(path, 0600, options)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := NewBoltStore(db, bucket)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	store.handle.owned = true
+	store.handle.options = options
+
+	return store, nil
+}
+
+// closes the db if it was opened by OpenBoltStore
+func (store *BoltStore) Close() error {
+	if !store.handle.owned {
+		return nil
+	}
+
+	store.handle.mutex.Lock()
+	defer store.handle.mutex.Unlock()
+	return store.handle.db.Close()
+}
+
+func (store *BoltStore) bucket(tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	bucket := tx.Bucket(store.path[0])
+	for _, name := range store.path[1:] {
+		if bucket == nil {
+			break
+		}
+		bucket = bucket.Bucket(name)
+	}
+
+	if bucket == nil {
+		return nil, errors.New("bucket not found")
+	}
+
+	return bucket, nil
+}
+
+func (store *BoltStore) view(fn func(bucket *bbolt.Bucket) error) error {
+	store.handle.mutex.RLock()
+	defer store.handle.mutex.RUnlock()
+
+	return store.handle.db.View(func(tx *bbolt.Tx) error {
+		bucket, err := store.bucket(tx)
+		if err != nil {
+			return err
+		}
+		return fn(bucket)
+	})
+}
+
+func (store *BoltStore) update(fn func(bucket *bbolt.Bucket) error) error {
+	store.handle.mutex.RLock()
+	defer store.handle.mutex.RUnlock()
+
+	return store.handle.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := store.bucket(tx)
+		if err != nil {
+			return err
+		}
+		return fn(bucket)
+	})
+}
+
+func (store *BoltStore) Get(key string) ([]byte, error) {
+	var bytes []byte
+
+	err := store.view(func(bucket *bbolt.Bucket) error {
+		found := bucket.Get([]byte(key))
+		if found == nil {
+			return ErrNotFound
+		}
+
+		bytes = make([]byte, len(found))
+		copy(bytes, found)
+
+		return nil
+	})
+
+	return bytes, err
+}
+
+func (store *BoltStore) Put(key string, value []byte) error {
+	return store.update(func(bucket *bbolt.Bucket) error {
+		return bucket.Put([]byte(key), value)
+	})
+}
+
+func (store *BoltStore) Delete(key string) error {
+	return store.update(func(bucket *bbolt.Bucket) error {
+		return bucket.Delete([]byte(key))
+	})
+}
+
+func (store *BoltStore) List() ([]string, error) {
+	var keys []string
+
+	err := store.view(func(bucket *bbolt.Bucket) error {
+		return bucket.ForEach(func(key, value []byte) error {
+			// nested buckets have no value
+			if value != nil {
+				keys = append(keys, string(key))
+			}
+			return nil
+		})
+	})
+
+	return keys, err
+}
+
+// nested bucket
+func (store *BoltStore) Sub(name string) (Store, error) {
+	sub := &BoltStore{
+		handle: store.handle,
+		path:   append(append([][]byte{}, store.path...), []byte(name)),
+	}
+
+	err := store.update(func(bucket *bbolt.Bucket) error {
+		_, err := bucket.CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("failed to make cache bucket: " + err.Error())
+	}
+
+	return sub, nil
+}
+
+// current db. changes after Compact
+func (store *BoltStore) DB() *bbolt.DB {
+	store.handle.mutex.RLock()
+	defer store.handle.mutex.RUnlock()
+	return store.handle.db
+}
+
+// writes a copy of the db without free pages to path. the db stays open, so
+// swap the files yourself before it's next opened
+func (store *BoltStore) CompactTo(path string) (CompactStats, error) {
+	handle := store.handle
+
+	// writers would change the db while it's copied
+	handle.mutex.Lock()
+	defer handle.mutex.Unlock()
+
+	return compactBolt(handle.db, path)
+}
+
+func compactBolt(db *bbolt.DB, path string) (CompactStats, error) {
+	var stats CompactStats
+
+	info, err := os.Stat(db.Path())
+	if err != nil {
+		return stats, err
+	}
+	stats.SizeBefore = info.Size()
+
+	dst, err := bbolt.Open(path, info.Mode(), nil)
+	if err != nil {
+		return stats, err
+	}
+
+	err = bbolt.Compact(dst, db, 0)
+	if err != nil {
+		dst.Close()
+		os.Remove(path)
+		return stats, err
+	}
+
+	err = dst.Close()
+	if err != nil {
+		os.Remove(path)
+		return stats, err
+	}
+
+	info, err = os.Stat(path)
+	if err != nil {
+		return stats, err
+	}
+	stats.SizeAfter = info.Size()
+
+	return stats, nil
+}
+
+// rewrites the db file without free pages. only for stores from
+// OpenBoltStore, since the db is closed and reopened. use CompactTo otherwise
+func (store *BoltStore) Compact() (CompactStats, error) {
+	handle := store.handle
+
+	if !handle.owned {
+		return CompactStats{}, errSharedBolt
+	}
+
+	handle.mutex.Lock()
+	defer handle.mutex.Unlock()
+
+	path := handle.db.Path()
+	tmpPath := path + ".compact"
+
+	stats, err := compactBolt(handle.db, tmpPath)
+	if err != nil {
+		return stats, err
+	}
+
+	err = handle.db.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return stats, err
+	}
+
+	// reopen whichever file we ended up with
+	renameErr := os.Rename(tmpPath, path)
+
+	db, err := bbolt.Open(path, 0600, handle.options)
+	if err != nil {
+		// closed, so later calls error instead of using a nil db
+		return stats, errors.New("failed to reopen db: " + err.Error())
+	}
+	handle.db = db
+
+	if renameErr != nil {
+		os.Remove(tmpPath)
+		stats.SizeAfter = stats.SizeBefore
+		return stats, renameErr
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,107 @@
+package foxcache
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const dirStoreExt = ".json"
+
+// one file per key in a directory
+type DirStore struct {
+	dir string
+}
+
+// creates dir if it doesn't exist
+func NewDirStore(dir string) (*DirStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.New("failed to make cache dir: " + err.Error())
+	}
+
+	return &DirStore{
+		dir: dir,
+	}, nil
+}
+
+func (store *DirStore) path(key string) string {
+	// keys can have slashes
+	return filepath.Join(store.dir, url.PathEscape(key)+dirStoreExt)
+}
+
+func (store *DirStore) Get(key string) ([]byte, error) {
+	bytes, err := os.ReadFile(store.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return bytes, err
+}
+
+func (store *DirStore) Put(key string, value []byte) error {
+	// write then rename so readers never see half a file
+	file, err := os.CreateTemp(store.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(value)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), store.path(key))
+}
+
+func (store *DirStore) Delete(key string) error {
+	err := os.Remove(store.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (store *DirStore) List() ([]string, error) {
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, dirStoreExt) {
+			continue
+		}
+
+		key, err := url.PathUnescape(strings.TrimSuffix(name, dirStoreExt))
+		if err != nil {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// subdirectory
+func (store *DirStore) Sub(name string) (Store, error) {
+	name = url.PathEscape(name)
+	if name == "." || name == ".." {
+		name = strings.ReplaceAll(name, ".", "%2E")
+	}
+	return NewDirStore(filepath.Join(store.dir, name))
+}
@@ -0,0 +1,66 @@
+package foxcache
+
+import (
+	"maps"
+	"slices"
+	"sync"
+)
+
+// nothing is persisted. useful for tests
+type MemoryStore struct {
+	values map[string][]byte
+	subs   map[string]*MemoryStore
+	mutex  sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		values: map[string][]byte{},
+		subs:   map[string]*MemoryStore{},
+	}
+}
+
+func (store *MemoryStore) Get(key string) ([]byte, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	value, ok := store.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return slices.Clone(value), nil
+}
+
+func (store *MemoryStore) Put(key string, value []byte) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.values[key] = slices.Clone(value)
+	return nil
+}
+
+func (store *MemoryStore) Delete(key string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.values, key)
+	return nil
+}
+
+func (store *MemoryStore) List() ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return slices.Sorted(maps.Keys(store.values)), nil
+}
+
+func (store *MemoryStore) Sub(name string) (Store, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	sub, ok := store.subs[name]
+	if !ok {
+		sub = NewMemoryStore()
+		store.subs[name] = sub
+	}
+
+	return sub, nil
+}
@@ -0,0 +1,144 @@
+package foxcache
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type SweepStats struct {
+	Expired  int
+	Orphaned int
+	// zero unless compacted
+	Compact CompactStats
+}
+
+// just enough of cache data to know when it expires
+type cacheHeader struct {
+	Expires time.Time `json:"expires"`
+}
+
+// deletes orphaned keys, which no registered data owns, and expired memo
+// entries. expired data is kept since it's served stale until refreshed.
+// history of keys that are gone or no longer keep it counts as orphaned.
+// compacts the store after if compact is set and the store supports it
+func (c *Cache) Sweep(compact bool) (SweepStats, error) {
+	var stats SweepStats
+
+	keys, err := c.store.List()
+	if err != nil {
+		return stats, err
+	}
+
+	now := c.clock.Now()
+
+	var errs []error
+	for _, key := range keys {
+		data := c.owner(key)
+		if data != nil {
+			if !data.sweepable() {
+				continue
+			}
+
+			bytes, err := c.store.Get(key)
+			if err != nil {
+				continue
+			}
+
+			var header cacheHeader
+			bytes, err = c.open(key, bytes)
+			if err == nil {
+				err = c.codec.Unmarshal(bytes, &header)
+			}
+
+			// undecodable data would be a miss anyway
+			if err == nil && now.Before(header.Expires) {
+				continue
+			}
+
+			stats.Expired++
+		} else {
+			stats.Orphaned++
+		}
+
+		err = c.store.Delete(key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	orphaned, err := c.sweepHistory()
+	stats.Orphaned += orphaned
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if compact {
+		compactor, ok := c.store.(Compactor)
+		if ok {
+			stats.Compact, err = compactor.Compact()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to compact: %w", err))
+			}
+		}
+	}
+
+	return stats, errors.Join(errs...)
+}
+
+func (c *Cache) sweepHistory() (int, error) {
+	store, err := c.historyStore()
+	if err != nil {
+		return 0, err
+	}
+
+	keys, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+
+	orphaned := 0
+	var errs []error
+	for _, historyKey := range keys {
+		key, _ := splitHistoryKey(historyKey)
+		data, err := c.get(key)
+		if err == nil && data.keepsHistory() {
+			continue
+		}
+
+		orphaned++
+		err = store.Delete(historyKey)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", historyKey, err))
+		}
+	}
+
+	return orphaned, errors.Join(errs...)
+}
+
+func (c *Cache) owner(key string) DataInterface {
+	c.entriesMutex.RLock()
+	defer c.entriesMutex.RUnlock()
+
+	for _, data := range c.entries {
+		if data.owns(key) {
+			return data
+		}
+	}
+
+	return nil
+}
+
+func (c *Cache) sweep() {
+	stats, err := c.Sweep(c.compact)
+	if err != nil {
+		slog.Error("failed to sweep cache", "err", err.Error())
+	}
+
+	slog.Info(
+		"swept cache",
+		"expired", stats.Expired, "orphaned", stats.Orphaned,
+		"reclaimed", stats.Compact.Reclaimed(),
+	)
+}
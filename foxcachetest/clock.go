@@ -0,0 +1,102 @@
+package foxcachetest
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/makinori/foxlib/foxcache"
+)
+
+// only moves when told to. timers fire during Advance, in the calling
+// goroutine, in order
+type Clock struct {
+	now    time.Time
+	timers []*timer
+	mutex  sync.Mutex
+}
+
+type timer struct {
+	clock *Clock
+	when  time.Time
+	f     func()
+}
+
+func NewClock(now time.Time) *Clock {
+	return &Clock{
+		now: now,
+	}
+}
+
+func (clock *Clock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *Clock) AfterFunc(d time.Duration, f func()) foxcache.Timer {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	timer := &timer{
+		clock: clock,
+		when:  clock.now.Add(d),
+		f:     f,
+	}
+	clock.timers = append(clock.timers, timer)
+
+	return timer
+}
+
+func (timer *timer) Stop() bool {
+	clock := timer.clock
+
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	i := slices.Index(clock.timers, timer)
+	if i == -1 {
+		return false
+	}
+
+	clock.timers = slices.Delete(clock.timers, i, i+1)
+	return true
+}
+
+// moves time forward, firing timers that are due on the way
+func (clock *Clock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	target := clock.now.Add(d)
+	clock.mutex.Unlock()
+
+	for {
+		clock.mutex.Lock()
+
+		var next *timer
+		for _, timer := range clock.timers {
+			if timer.when.After(target) {
+				continue
+			}
+			if next == nil || timer.when.Before(next.when) {
+				next = timer
+			}
+		}
+
+		if next == nil {
+			clock.now = target
+			clock.mutex.Unlock()
+			return
+		}
+
+		clock.timers = slices.DeleteFunc(clock.timers, func(timer *timer) bool {
+			return timer == next
+		})
+		if next.when.After(clock.now) {
+			clock.now = next.when
+		}
+
+		clock.mutex.Unlock()
+
+		next.f()
+	}
+}
@@ -0,0 +1,49 @@
+package foxcachetest
+
+import (
+	"time"
+
+	"github.com/makinori/foxlib/foxcache"
+)
+
+var (
+	// where clocks made by New start
+	Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// deterministic cache for tests. Init fetches missing data right away as
+// usual, after that nothing runs unless the test advances the clock or ticks
+// a key. nothing touches the disk
+type Harness struct {
+	Cache     *foxcache.Cache
+	Clock     *Clock
+	Scheduler *Scheduler
+	Store     *foxcache.MemoryStore
+}
+
+// clock, scheduler and store in opts are replaced
+func New(opts foxcache.Options) *Harness {
+	clock := NewClock(Epoch)
+	scheduler := NewScheduler(clock)
+	store := foxcache.NewMemoryStore()
+
+	opts.Clock = clock
+	opts.Scheduler = scheduler
+
+	return &Harness{
+		Cache:     foxcache.NewWithStore(store, opts),
+		Clock:     clock,
+		Scheduler: scheduler,
+		Store:     store,
+	}
+}
+
+// moves the clock forward, running scheduled refreshes and retries on the way
+func (harness *Harness) Advance(d time.Duration) {
+	harness.Clock.Advance(d)
+}
+
+// runs the scheduled refresh for key right away
+func (harness *Harness) Tick(key string) error {
+	return harness.Scheduler.Tick(key)
+}
@@ -0,0 +1,97 @@
+package foxcachetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makinori/foxlib/foxcache"
+)
+
+func TestRefreshAfterExpiry(t *testing.T) {
+	harness := New(foxcache.Options{})
+
+	value := 1
+	var err error
+	calls := 0
+	data := &foxcache.Data[int]{
+		Key:   "counter",
+		Every: time.Hour,
+		Retrieve: func() (int, error) {
+			calls++
+			return value, err
+		},
+	}
+
+	initErr := harness.Cache.Init([]foxcache.DataInterface{data})
+	if initErr != nil {
+		t.Fatal(initErr)
+	}
+
+	// cached
+	snapshot := data.Snapshot()
+	if snapshot.Value != 1 || snapshot.Stale || calls != 1 {
+		t.Fatalf("after init got %+v with %d calls", snapshot, calls)
+	}
+
+	harness.Advance(snapshot.Expires.Sub(harness.Clock.Now()) - time.Second)
+	if data.Snapshot().Stale || calls != 1 {
+		t.Fatal("expected nothing to run before expiry")
+	}
+
+	// expired, the scheduled refresh fails so the old value is served stale
+	value = 2
+	err = errors.New("down")
+	harness.Advance(2 * time.Second)
+
+	snapshot = data.Snapshot()
+	if snapshot.Value != 1 || !snapshot.Stale || calls != 2 {
+		t.Fatalf("after expiry got %+v with %d calls", snapshot, calls)
+	}
+
+	// refreshed
+	err = nil
+	tickErr := harness.Tick("counter")
+	if tickErr != nil {
+		t.Fatal(tickErr)
+	}
+
+	snapshot = data.Snapshot()
+	if snapshot.Value != 2 || snapshot.Stale || calls != 3 {
+		t.Fatalf("after refresh got %+v with %d calls", snapshot, calls)
+	}
+	if !snapshot.Updated.Equal(harness.Clock.Now()) {
+		t.Fatalf("updated %v, want %v", snapshot.Updated, harness.Clock.Now())
+	}
+}
+
+func TestRetryIsDeterministic(t *testing.T) {
+	retryAt := func() time.Time {
+		harness := New(foxcache.Options{})
+
+		var attempts []time.Time
+		data := &foxcache.Data[int]{
+			Key:   "flaky",
+			Every: time.Hour,
+			Retry: foxcache.RetryPolicy{Attempts: 1},
+			Retrieve: func() (int, error) {
+				attempts = append(attempts, harness.Clock.Now())
+				return 0, errors.New("down")
+			},
+		}
+
+		harness.Cache.Init([]foxcache.DataInterface{data})
+		harness.Advance(time.Minute)
+
+		if len(attempts) != 2 {
+			t.Fatalf("got %d attempts, want 2", len(attempts))
+		}
+		return attempts[1]
+	}
+
+	first := retryAt()
+	second := retryAt()
+	if !first.Equal(second) {
+		t.Fatalf("retries at %v and %v", first, second)
+	}
+}
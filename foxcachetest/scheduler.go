@@ -0,0 +1,140 @@
+package foxcachetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/makinori/foxlib/foxcache"
+	"github.com/robfig/cron/v3"
+)
+
+// runs jobs from the clock instead of real time. jobs run in the goroutine
+// calling Advance or Tick
+type Scheduler struct {
+	clock   *Clock
+	jobs    map[string]*job
+	started bool
+	stopped bool
+	mutex   sync.Mutex
+}
+
+type job struct {
+	schedule cron.Schedule
+	run      func()
+	next     time.Time
+	timer    foxcache.Timer
+}
+
+func NewScheduler(clock *Clock) *Scheduler {
+	return &Scheduler{
+		clock: clock,
+		jobs:  map[string]*job{},
+	}
+}
+
+func (scheduler *Scheduler) Schedule(
+	key string, schedule cron.Schedule, run func(),
+) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	job := &job{
+		schedule: schedule,
+		run:      run,
+	}
+	scheduler.jobs[key] = job
+
+	if scheduler.started {
+		scheduler.arm(key, job)
+	}
+}
+
+// expects lock
+func (scheduler *Scheduler) arm(key string, job *job) {
+	if job.timer != nil {
+		job.timer.Stop()
+	}
+
+	now := scheduler.clock.Now()
+	job.next = job.schedule.Next(now)
+	job.timer = scheduler.clock.AfterFunc(job.next.Sub(now), func() {
+		scheduler.fire(key)
+	})
+}
+
+func (scheduler *Scheduler) fire(key string) {
+	scheduler.mutex.Lock()
+	job, ok := scheduler.jobs[key]
+	if !ok || scheduler.stopped {
+		scheduler.mutex.Unlock()
+		return
+	}
+	// like cron, next run is known before the job runs
+	scheduler.arm(key, job)
+	scheduler.mutex.Unlock()
+
+	job.run()
+}
+
+func (scheduler *Scheduler) Next(key string) time.Time {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	job, ok := scheduler.jobs[key]
+	if !ok {
+		return time.Time{}
+	}
+
+	return job.next
+}
+
+func (scheduler *Scheduler) Start() {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	if scheduler.started {
+		return
+	}
+	scheduler.started = true
+	scheduler.stopped = false
+
+	for key, job := range scheduler.jobs {
+		scheduler.arm(key, job)
+	}
+}
+
+func (scheduler *Scheduler) Stop() context.Context {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	scheduler.started = false
+	scheduler.stopped = true
+
+	for _, job := range scheduler.jobs {
+		if job.timer != nil {
+			job.timer.Stop()
+			job.timer = nil
+		}
+	}
+
+	// jobs run synchronously so nothing is left running
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+// runs a job right away as if its time had come
+func (scheduler *Scheduler) Tick(key string) error {
+	scheduler.mutex.Lock()
+	_, ok := scheduler.jobs[key]
+	scheduler.mutex.Unlock()
+
+	if !ok {
+		return errors.New("no job scheduled for " + key)
+	}
+
+	scheduler.fire(key)
+	return nil
+}
@@ -21,6 +21,12 @@ var (
 	pageStylesKey pageStylesKeyType = "foxcssPageStyles"
 )
 
+// can't name keyframes, so never used as names
+var reservedNames = []string{
+	"initial", "inherit", "unset", "revert", "revert-layer", "default",
+	"none", "auto",
+}
+
 type hashWords struct {
 	available []string
 	cache     map[string]string
@@ -28,7 +34,9 @@ type hashWords struct {
 }
 
 type pageStyles struct {
-	classMap    *orderedmap.OrderedMap[string, string]
+	classMap *orderedmap.OrderedMap[string, string]
+	// by hash of the css, emitted before classes
+	globalMap   *orderedmap.OrderedMap[uint64, string]
 	mutex       sync.RWMutex
 	hashWords   *hashWords
 	classPrefix string
@@ -67,6 +75,7 @@ func InitContext(ctx context.Context, classPrefix string) context.Context {
 		ctx, pageStylesKey,
 		&pageStyles{
 			classMap:    orderedmap.NewOrderedMap[string, string](),
+			globalMap:   orderedmap.NewOrderedMap[uint64, string](),
 			mutex:       sync.RWMutex{},
 			classPrefix: classPrefix,
 		},
@@ -97,6 +106,10 @@ func UseWords(
 		word = strings.ToLower(word)
 		word = strings.ReplaceAll(word, " ", "-")
 
+		if slices.Contains(reservedNames, word) {
+			continue
+		}
+
 		if !slices.Contains(pageStyles.hashWords.available, word) {
 			pageStyles.hashWords.available = append(
 				pageStyles.hashWords.available, word,
@@ -107,7 +120,8 @@ func UseWords(
 	return nil
 }
 
-func (pageStyles *pageStyles) getClassName(snippet string) string {
+// initial is used without prefix or words, since hashes can start with a digit
+func (pageStyles *pageStyles) getName(snippet string, initial string) string {
 	hash64 := xxhash.Sum64([]byte(snippet))
 	hash32 := uint32(hash64>>32) ^ uint32(hash64)
 	className := strconv.FormatUint(uint64(hash32), 36)
@@ -118,8 +132,7 @@ func (pageStyles *pageStyles) getClassName(snippet string) string {
 
 	if pageStyles.classPrefix == "" {
 		if pageStyles.hashWords == nil {
-			// c for class
-			className = "c" + className
+			className = initial + className
 		}
 	} else {
 		className = pageStyles.classPrefix + className
@@ -128,6 +141,11 @@ func (pageStyles *pageStyles) getClassName(snippet string) string {
 	return className
 }
 
+func (pageStyles *pageStyles) getClassName(snippet string) string {
+	// c for class
+	return pageStyles.getName(snippet, "c")
+}
+
 func (pageStyles *pageStyles) hasClassNameSafe(className string) bool {
 	pageStyles.mutex.RLock()
 	defer pageStyles.mutex.RUnlock()
@@ -156,10 +174,65 @@ func Class(ctx context.Context, snippet string) string {
 
 	pageStyles.mutex.Lock()
 	defer pageStyles.mutex.Unlock()
-	pageStyles.classMap.Set(className, preprocess(snippet))
+	pageStyles.classMap.Set(className, preprocess(snippet, "."+className))
 	return className
 }
 
+// returns animation name and injects keyframes into page styles.
+// body holds the frames, like from { opacity: 0 } to { opacity: 1 }
+func Keyframes(ctx context.Context, body string) string {
+	if body == "" {
+		return ""
+	}
+
+	pageStyles, ok := ctx.Value(
+		pageStylesKey,
+	).(*pageStyles)
+	if !ok {
+		slog.Error("failed to get page styles from context")
+		return ""
+	}
+
+	// k for keyframes. hashed apart from a class with the same snippet
+	name := pageStyles.getName("@keyframes{"+body+"}", "k")
+
+	if pageStyles.hasClassNameSafe(name) {
+		return name
+	}
+
+	pageStyles.mutex.Lock()
+	defer pageStyles.mutex.Unlock()
+	pageStyles.classMap.Set(
+		name, "@keyframes "+name+"{"+preprocess(body, "")+"}",
+	)
+	return name
+}
+
+// injects page level rules like resets, @font-face and :root variables.
+// they come before keyframes and classes, in the order first used.
+// declarations outside a rule are logged and dropped
+func Global(ctx context.Context, css string) {
+	if css == "" {
+		return
+	}
+
+	pageStyles, ok := ctx.Value(
+		pageStylesKey,
+	).(*pageStyles)
+	if !ok {
+		slog.Error("failed to get page styles from context")
+		return
+	}
+
+	hash := xxhash.Sum64String(css)
+
+	pageStyles.mutex.Lock()
+	defer pageStyles.mutex.Unlock()
+	if !pageStyles.globalMap.Has(hash) {
+		pageStyles.globalMap.Set(hash, preprocess(css, ""))
+	}
+}
+
 func GetPageCSS(ctx context.Context) string {
 	pageStyles, ok := ctx.Value(
 		pageStylesKey,
@@ -171,9 +244,17 @@ func GetPageCSS(ctx context.Context) string {
 
 	var css string
 
+	pageStyles.mutex.RLock()
+	defer pageStyles.mutex.RUnlock()
+
+	globalMap := pageStyles.globalMap
+	for style := globalMap.Front(); style != nil; style = style.Next() {
+		css += style.Value
+	}
+
 	classMap := pageStyles.classMap
 	for style := classMap.Front(); style != nil; style = style.Next() {
-		css += strings.ReplaceAll(style.Value, "&", "."+style.Key)
+		css += style.Value
 	}
 
 	return css
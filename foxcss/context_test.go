@@ -0,0 +1,69 @@
+package foxcss
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPageCSS(t *testing.T) {
+	ctx := InitContext(context.Background(), "x-")
+
+	first := Class(ctx, "color: red")
+	fade := Keyframes(ctx, "from { opacity: 0 } to { opacity: 1 }")
+	Global(ctx, "body { margin: 0 }")
+	second := Class(ctx, "color: blue")
+
+	// deduped
+	if Class(ctx, "color: red") != first {
+		t.Error("same snippet got another class")
+	}
+	if Keyframes(ctx, "from { opacity: 0 } to { opacity: 1 }") != fade {
+		t.Error("same keyframes got another name")
+	}
+	Global(ctx, "body { margin: 0 }")
+
+	for _, name := range []string{first, fade, second} {
+		if !strings.HasPrefix(name, "x-") {
+			t.Errorf("%s missing prefix", name)
+		}
+	}
+	if first == second || first == fade {
+		t.Errorf("names collide: %s %s %s", first, fade, second)
+	}
+
+	// globals first, then classes and keyframes as used
+	want := "body{margin: 0;}" +
+		"." + first + "{color: red;}" +
+		"@keyframes " + fade + "{from{opacity: 0;}to{opacity: 1;}}" +
+		"." + second + "{color: blue;}"
+	got := GetPageCSS(ctx)
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNames(t *testing.T) {
+	ctx := InitContext(context.Background(), "")
+
+	class := Class(ctx, "color: red")
+	keyframes := Keyframes(ctx, "to { opacity: 1 }")
+	if !strings.HasPrefix(class, "c") || !strings.HasPrefix(keyframes, "k") {
+		t.Errorf("got %s and %s", class, keyframes)
+	}
+
+	// css wide keywords can't name keyframes
+	ctx = InitContext(context.Background(), "")
+	err := UseWords(ctx, []string{"none", "Inherit", "fox", "Red Panda"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]bool{
+		Class(ctx, "color: red"):            true,
+		Keyframes(ctx, "to { opacity: 1 }"): true,
+	}
+	if !names["fox"] || !names["red-panda"] {
+		t.Errorf("got %v, want fox and red-panda", names)
+	}
+}
@@ -1,51 +1,506 @@
 package foxcss
 
-import "strings"
+import (
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+)
 
-// replace all & with class name afterwards
-func preprocess(input string) (css string) {
-	css = "&{"
-	outOfMain := false
+type tokenKind int
 
-	for line := range strings.SplitSeq(input, "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
+const (
+	// idents, strings, urls and anything else
+	tokenText tokenKind = iota
+	tokenSpace
+	tokenNewline
+	tokenOpenParen
+	tokenCloseParen
+	tokenSemicolon
+	tokenOpenBrace
+	tokenCloseBrace
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// comments are dropped. strings and urls are kept whole so their contents
+// dont count as syntax
+func tokenize(input string) []token {
+	var tokens []token
+
+	for i := 0; i < len(input); {
+		start := i
+		kind := tokenText
+
+		switch c := input[i]; {
+		case c == '\n':
+			kind = tokenNewline
+			i++
+
+		case isSpace(c):
+			kind = tokenSpace
+			for i < len(input) && isSpace(input[i]) {
+				i++
+			}
+
+		case strings.HasPrefix(input[i:], "/*"):
+			end := strings.Index(input[i+2:], "*/")
+			if end < 0 {
+				i = len(input)
+			} else {
+				i += 2 + end + 2
+			}
+			tokens = append(tokens, token{kind: tokenSpace, text: " "})
 			continue
+
+		// not css but people write them anyway
+		case strings.HasPrefix(input[i:], "//"):
+			for i < len(input) && input[i] != '\n' {
+				i++
+			}
+			continue
+
+		case c == '"' || c == '\'':
+			i = stringEnd(input, i)
+
+		case c == '\\':
+			i = min(i+2, len(input))
+
+		case isURLStart(input, i):
+			i = urlEnd(input, i)
+
+		case c == '(':
+			kind = tokenOpenParen
+			i++
+
+		case c == ')':
+			kind = tokenCloseParen
+			i++
+
+		case c == ';':
+			kind = tokenSemicolon
+			i++
+
+		case c == '{':
+			kind = tokenOpenBrace
+			i++
+
+		case c == '}':
+			kind = tokenCloseBrace
+			i++
+
+		case isIdent(c):
+			for i < len(input) && isIdent(input[i]) {
+				i++
+			}
+
+		default:
+			i++
 		}
 
-		// ignore comments
-		if strings.HasPrefix(line, "//") {
+		tokens = append(tokens, token{kind: kind, text: input[start:i]})
+	}
+
+	return tokens
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\f'
+}
+
+func isIdent(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' || c == '-' || c == '_'
+}
+
+func isURLStart(input string, i int) bool {
+	return len(input)-i >= 4 && strings.EqualFold(input[i:i+4], "url(") &&
+		(i == 0 || !isIdent(input[i-1]))
+}
+
+// index after the closing quote
+func stringEnd(input string, i int) int {
+	quote := input[i]
+	for i++; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		case '\n':
+			return i
+		}
+	}
+	return len(input)
+}
+
+// index after the closing paren
+func urlEnd(input string, i int) int {
+	for i += 4; i < len(input); {
+		switch input[i] {
+		case '"', '\'':
+			i = stringEnd(input, i)
+		case ')':
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return len(input)
+}
+
+// either a declaration or a rule with a block
+type cssNode struct {
+	// selector or at-rule for blocks, otherwise the declaration
+	text     string
+	block    bool
+	children []cssNode
+}
+
+var declarationStart = regexp.MustCompile(`^-*[a-zA-Z_][a-zA-Z0-9_-]*\s*:`)
+
+type cssParser struct {
+	tokens []token
+	pos    int
+}
+
+// until the closing brace or end of input
+func (parser *cssParser) parseBlock() []cssNode {
+	var nodes []cssNode
+	var text strings.Builder
+	parens := 0
+
+	flush := func() {
+		declaration := strings.TrimSpace(text.String())
+		if declaration != "" {
+			nodes = append(nodes, cssNode{text: declaration})
+		}
+		text.Reset()
+	}
+
+	for parser.pos < len(parser.tokens) {
+		token := parser.tokens[parser.pos]
+		parser.pos++
+
+		switch {
+		case token.kind == tokenOpenParen:
+			parens++
+
+		case token.kind == tokenCloseParen:
+			parens = max(parens-1, 0)
+
+		case parens > 0:
+
+		case token.kind == tokenSemicolon:
+			flush()
+			continue
+
+		case token.kind == tokenOpenBrace:
+			prelude := strings.TrimSpace(text.String())
+			text.Reset()
+			nodes = append(nodes, cssNode{
+				text:     prelude,
+				block:    true,
+				children: parser.parseBlock(),
+			})
+			continue
+
+		case token.kind == tokenCloseBrace:
+			flush()
+			return nodes
+
+		case token.kind == tokenNewline && parser.endsDeclaration(text.String()):
+			flush()
 			continue
 		}
 
-		// parse nesting
-		// can only nest from root though
-		if strings.HasSuffix(line, "{") {
-			if !outOfMain {
-				outOfMain = true
-				css += "}"
+		// collapse whitespace
+		if token.kind == tokenSpace || token.kind == tokenNewline {
+			if text.Len() > 0 && !strings.HasSuffix(text.String(), " ") {
+				text.WriteByte(' ')
 			}
-			if !strings.HasPrefix(line, "@") &&
-				!strings.HasPrefix(line, "&") {
-				css += "& "
+			continue
+		}
+
+		text.WriteString(token.text)
+	}
+
+	flush()
+	return nodes
+}
+
+// semicolons can be left out at the end of a line, unless the value or
+// selector continues on the next one
+func (parser *cssParser) endsDeclaration(text string) bool {
+	text = strings.TrimSpace(text)
+	// also false for selectors starting with &, ., #, :, [ or a combinator
+	if !declarationStart.MatchString(text) ||
+		strings.HasSuffix(text, ":") || strings.HasSuffix(text, ",") {
+		return false
+	}
+
+	i := parser.pos
+	for i < len(parser.tokens) &&
+		(parser.tokens[i].kind == tokenSpace ||
+			parser.tokens[i].kind == tokenNewline) {
+		i++
+	}
+	if i == len(parser.tokens) {
+		return true
+	}
+
+	switch parser.tokens[i].kind {
+	case tokenCloseBrace, tokenSemicolon:
+		return true
+	case tokenOpenBrace:
+		// brace on its own line after a selector
+		return false
+	}
+
+	if strings.HasPrefix(parser.tokens[i].text, "@") {
+		return true
+	}
+
+	// like a:hover, which continues on the next line if a rule follows
+	if isPseudoSelector(text) {
+		return !parser.ruleFollows(i)
+	}
+
+	var line strings.Builder
+	for j := i; j < len(parser.tokens) &&
+		parser.tokens[j].kind != tokenNewline; j++ {
+		line.WriteString(parser.tokens[j].text)
+	}
+	if declarationStart.MatchString(line.String()) {
+		return true
+	}
+
+	return parser.ruleFollows(i)
+}
+
+// whether a block opens before the declaration or block ends
+func (parser *cssParser) ruleFollows(i int) bool {
+	parens := 0
+	for ; i < len(parser.tokens); i++ {
+		switch parser.tokens[i].kind {
+		case tokenOpenParen:
+			parens++
+		case tokenCloseParen:
+			parens = max(parens-1, 0)
+		case tokenSemicolon, tokenCloseBrace:
+			if parens == 0 {
+				return false
+			}
+		case tokenOpenBrace:
+			if parens == 0 {
+				return true
 			}
 		}
+	}
+
+	return false
+}
+
+var pseudoSelectorStart = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*:(:|[a-zA-Z-]+)`)
+
+// pseudo classes that are never values, so color:red isn't one
+var pseudoClasses = []string{
+	"hover", "focus", "focus-visible", "focus-within", "active", "visited",
+	"link", "any-link", "target", "checked", "disabled", "enabled",
+	"required", "optional", "valid", "invalid", "placeholder-shown",
+	"read-only", "read-write", "indeterminate", "first-child", "last-child",
+	"only-child", "first-of-type", "last-of-type", "only-of-type",
+	"nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type",
+	"not", "is", "where", "has", "empty", "root", "before", "after",
+}
+
+func isPseudoSelector(text string) bool {
+	match := pseudoSelectorStart.FindStringSubmatch(text)
+	if match == nil {
+		return false
+	}
+	return match[1] == ":" || slices.Contains(pseudoClasses, match[1])
+}
+
+// splits on top level commas
+func splitSelector(selector string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+
+	for i := 0; i < len(selector); i++ {
+		switch selector[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth = max(depth-1, 0)
+		case '"', '\'':
+			i = stringEnd(selector, i) - 1
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(selector[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, strings.TrimSpace(selector[start:]))
+}
+
+// replaces & outside of strings
+func replaceNesting(selector, parent string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(selector); i++ {
+		switch selector[i] {
+		case '"', '\'':
+			end := stringEnd(selector, i)
+			out.WriteString(selector[i:end])
+			i = end - 1
+		case '\\':
+			end := min(i+2, len(selector))
+			out.WriteString(selector[i:end])
+			i = end - 1
+		case '&':
+			out.WriteString(parent)
+		default:
+			out.WriteByte(selector[i])
+		}
+	}
+
+	return out.String()
+}
+
+// & is replaced by the parent, otherwise the parent is the ancestor
+func resolveSelector(parent, nested string) string {
+	var selectors []string
+
+	for _, nestedPart := range splitSelector(nested) {
+		hasNesting := replaceNesting(nestedPart, "") != nestedPart
+
+		if parent == "" {
+			selectors = append(
+				selectors, strings.TrimSpace(replaceNesting(nestedPart, "")),
+			)
+			continue
+		}
 
-		// each css rule
-		if !strings.HasSuffix(line, "}") && !strings.HasSuffix(line, "{") &&
-			!strings.HasSuffix(line, ":") && !strings.HasSuffix(line, ",") {
-			// make sure ends with semicolon
-			if !strings.HasSuffix(line, ";") {
-				line += ";"
+		for _, parentPart := range splitSelector(parent) {
+			if hasNesting {
+				selectors = append(
+					selectors, replaceNesting(nestedPart, parentPart),
+				)
+			} else {
+				selectors = append(selectors, parentPart+" "+nestedPart)
 			}
 		}
+	}
+
+	return strings.Join(selectors, ",")
+}
+
+// flattens nodes nested in selector. declarations and rules keep their order
+func compile(nodes []cssNode, selector string) string {
+	var css strings.Builder
+	var declarations []string
+
+	flushDeclarations := func() {
+		if len(declarations) == 0 {
+			return
+		}
+		// like in Global, where there's nothing to apply them to
+		if selector == "" {
+			slog.Error(
+				"dropped css declarations outside a rule",
+				"declarations", strings.Join(declarations, ";"),
+			)
+			declarations = nil
+			return
+		}
+		css.WriteString(selector + "{")
+		for _, declaration := range declarations {
+			css.WriteString(declaration + ";")
+		}
+		css.WriteString("}")
+		declarations = nil
+	}
+
+	for _, node := range nodes {
+		switch {
+		case !node.block && strings.HasPrefix(node.text, "@"):
+			flushDeclarations()
+			css.WriteString(node.text + ";")
 
-		css += line
+		case !node.block:
+			declarations = append(declarations, node.text)
+
+		case strings.HasPrefix(node.text, "@"):
+			flushDeclarations()
+			css.WriteString(compileAtRule(node, selector))
+
+		default:
+			flushDeclarations()
+			css.WriteString(
+				compile(node.children, resolveSelector(selector, node.text)),
+			)
+		}
 	}
 
-	if !outOfMain {
-		css += "}"
+	flushDeclarations()
+
+	return css.String()
+}
+
+// at-rules that hold rules and wrap the selector when nested
+var groupingAtRules = []string{
+	"media", "supports", "container", "layer", "scope", "starting-style",
+}
+
+// lowercased without @
+func atRuleName(text string) string {
+	end := strings.IndexAny(text, " \t\n(")
+	if end < 0 {
+		end = len(text)
+	}
+	return strings.ToLower(text[1:end])
+}
+
+// grouping at-rules keep the selector, others like @font-face and @keyframes
+// are kept as written
+func compileAtRule(node cssNode, selector string) string {
+	if !slices.Contains(groupingAtRules, atRuleName(node.text)) {
+		return node.text + "{" + serialize(node.children) + "}"
+	}
+	return node.text + "{" + compile(node.children, selector) + "}"
+}
+
+// as written without resolving selectors
+func serialize(nodes []cssNode) string {
+	var css strings.Builder
+
+	for _, node := range nodes {
+		if node.block {
+			css.WriteString(node.text + "{" + serialize(node.children) + "}")
+		} else {
+			css.WriteString(node.text + ";")
+		}
+	}
+
+	return css.String()
+}
+
+// flattens nested css into standard css for selector
+func preprocess(input string, selector string) string {
+	parser := cssParser{tokens: tokenize(input)}
+
+	var css strings.Builder
+	for parser.pos < len(parser.tokens) {
+		// stray closing braces end a block early
+		css.WriteString(compile(parser.parseBlock(), selector))
 	}
 
-	return
+	return css.String()
 }
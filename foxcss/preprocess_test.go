@@ -0,0 +1,205 @@
+package foxcss
+
+import "testing"
+
+func TestPreprocess(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "declarations",
+			input: "display: flex;\ngap: 8px;",
+			want:  ".c{display: flex;gap: 8px;}",
+		},
+		{
+			name:  "missing semicolons",
+			input: "display: flex\ngap: 8px",
+			want:  ".c{display: flex;gap: 8px;}",
+		},
+		{
+			name: "deep nesting",
+			input: `
+				&:hover {
+					.icon {
+						> span { color: red }
+					}
+				}
+			`,
+			want: ".c:hover .icon > span{color: red;}",
+		},
+		{
+			name:  "nesting in any position",
+			input: ".parent & { color: red } & + & { margin: 0 }",
+			want:  ".parent .c{color: red;}.c + .c{margin: 0;}",
+		},
+		{
+			name:  "selector lists",
+			input: "a, b { .x, .y { color: red } }",
+			want:  ".c a .x,.c b .x,.c a .y,.c b .y{color: red;}",
+		},
+		{
+			name:  "order is kept",
+			input: "color: red; a { color: blue } margin: 0",
+			want:  ".c{color: red;}.c a{color: blue;}.c{margin: 0;}",
+		},
+		{
+			name: "multi line selector",
+			input: `
+				a:hover,
+				a:focus
+				{
+					color: red
+				}
+			`,
+			want: ".c a:hover,.c a:focus{color: red;}",
+		},
+		{
+			name: "multi line selector with nesting and a colon",
+			input: `
+				&:hover
+				  .icon { color: red }
+			`,
+			want: ".c:hover .icon{color: red;}",
+		},
+		{
+			name: "multi line selector with colons",
+			input: `
+				a:hover
+				span:focus { color: red }
+			`,
+			want: ".c a:hover span:focus{color: red;}",
+		},
+		{
+			name: "multi line selector with a pseudo element",
+			input: `
+				color: red
+				a::before
+				span { content: "x" }
+			`,
+			want: `.c{color: red;}.c a::before span{content: "x";}`,
+		},
+		{
+			name: "multi line values",
+			input: `
+				grid-template-areas:
+					"a b"
+					"c d";
+				font-family: x,
+					sans-serif
+				margin: 1px
+					2px
+			`,
+			want: `.c{grid-template-areas: "a b" "c d";font-family: x, sans-serif;margin: 1px 2px;}`,
+		},
+		{
+			name: "rule after value without semicolon",
+			input: `
+				color: red
+				.icon { color: blue }
+			`,
+			want: ".c{color: red;}.c .icon{color: blue;}",
+		},
+		{
+			name: "comments",
+			input: `
+				// line comment
+				color: red; /* block { comment; } */
+				/* multi
+				   line */
+				margin: 0 // trailing
+			`,
+			want: ".c{color: red;margin: 0;}",
+		},
+		{
+			name:  "strings with syntax",
+			input: `content: "a { b; c // d }"; quotes: '}' ';'`,
+			want:  `.c{content: "a { b; c // d }";quotes: '}' ';';}`,
+		},
+		{
+			name:  "urls with syntax",
+			input: "background: url(http://x.com/a;b{c}.png)\nmask: url('//x.com/}.svg')",
+			want:  ".c{background: url(http://x.com/a;b{c}.png);mask: url('//x.com/}.svg');}",
+		},
+		{
+			name:  "nesting in strings",
+			input: `a[title="x&y"] { color: red } &[data-x='&'] { color: blue }`,
+			want:  `.c a[title="x&y"]{color: red;}.c[data-x='&']{color: blue;}`,
+		},
+		{
+			name:  "parens",
+			input: "width: calc(100% -\n 2px); :is(a, b) & { color: red }",
+			want:  ".c{width: calc(100% - 2px);}:is(a, b) .c{color: red;}",
+		},
+		{
+			name:  "media",
+			input: "color: red; @media (max-width: 600px) { color: blue }",
+			want:  ".c{color: red;}@media (max-width: 600px){.c{color: blue;}}",
+		},
+		{
+			name:  "media in a nested rule",
+			input: "a { color: red; @media print { display: none } }",
+			want:  ".c a{color: red;}@media print{.c a{display: none;}}",
+		},
+		{
+			name: "nested media and supports",
+			input: `
+				@media screen {
+					@supports (display: grid) {
+						display: grid;
+						a { gap: 1px }
+					}
+				}
+			`,
+			want: "@media screen{@supports (display: grid){.c{display: grid;}.c a{gap: 1px;}}}",
+		},
+		{
+			name:  "layer and container",
+			input: "@layer base { color: red } @container card (min-width: 10em) { .x { color: blue } }",
+			want:  "@layer base{.c{color: red;}}@container card (min-width: 10em){.c .x{color: blue;}}",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := preprocess(test.input, ".c")
+			if got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPreprocessGlobal(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "rules",
+			input: "html, body { margin: 0; a { color: inherit } }",
+			want:  "html,body{margin: 0;}html a,body a{color: inherit;}",
+		},
+		{
+			name:  "declarations outside a rule are dropped",
+			input: "color: red; @media print { display: none; a { b: c } }",
+			want:  "@media print{a{b: c;}}",
+		},
+		{
+			name:  "at-rules kept as written",
+			input: "@font-face { font-family: x; src: url(a.woff) }",
+			want:  "@font-face{font-family: x;src: url(a.woff);}",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := preprocess(test.input, "")
+			if got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package foxcss
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var tokenNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+
+// would end the declaration, the rule or the style element
+const invalidTokenValueChars = ";{}<"
+
+// dark is optional and falls back to light
+type ThemeToken struct {
+	Light string
+	Dark  string
+}
+
+// design tokens as css custom properties. add CSS with Global
+type Theme struct {
+	tokens map[string]ThemeToken
+	// sorted so css is stable
+	names []string
+}
+
+// validates token names and values, so use at startup
+func NewTheme(tokens map[string]ThemeToken) (*Theme, error) {
+	theme := &Theme{
+		tokens: maps.Clone(tokens),
+		names:  slices.Sorted(maps.Keys(tokens)),
+	}
+
+	var errs []error
+	for _, name := range theme.names {
+		if !tokenNameRegexp.MatchString(name) {
+			errs = append(errs, fmt.Errorf("invalid token name %q", name))
+		} else if strings.TrimSpace(tokens[name].Light) == "" {
+			errs = append(errs, fmt.Errorf("token %q missing light value", name))
+		}
+
+		for _, value := range []string{tokens[name].Light, tokens[name].Dark} {
+			if strings.ContainsAny(value, invalidTokenValueChars) {
+				errs = append(errs, fmt.Errorf(
+					"token %q value %q can't contain any of %q",
+					name, value, invalidTokenValueChars,
+				))
+			} else if !closedTokenValue(value) {
+				errs = append(errs, fmt.Errorf(
+					"token %q value %q has an open string, paren, escape or "+
+						"a comment",
+					name, value,
+				))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return theme, nil
+}
+
+// whether value tokenizes as written and nothing after it is swallowed
+func closedTokenValue(value string) bool {
+	tokens := tokenize(value + ";")
+	if len(tokens) == 0 || tokens[len(tokens)-1].kind != tokenSemicolon {
+		return false
+	}
+
+	var written strings.Builder
+	parens := 0
+	for _, token := range tokens {
+		written.WriteString(token.text)
+
+		switch {
+		case token.text[0] == '"' || token.text[0] == '\'':
+			// strings end at a newline
+			end := len(token.text) - 1
+			if end < 1 || token.text[end] != token.text[0] {
+				return false
+			}
+		case token.kind == tokenOpenParen:
+			parens++
+		case token.kind == tokenCloseParen:
+			parens--
+		}
+		if parens < 0 {
+			return false
+		}
+	}
+
+	// comments are dropped
+	return parens == 0 && written.String() == value+";"
+}
+
+func MustNewTheme(tokens map[string]ThemeToken) *Theme {
+	theme, err := NewTheme(tokens)
+	if err != nil {
+		panic(err)
+	}
+	return theme
+}
+
+// returns var(--name) for use in snippets. panics on unknown names like
+// MustNewTheme, since tokens are fixed at startup
+func (theme *Theme) Var(name string) string {
+	_, ok := theme.tokens[name]
+	if !ok {
+		panic("unknown theme token: " + name)
+	}
+	return "var(--" + name + ")"
+}
+
+func (theme *Theme) properties(dark bool) string {
+	var css strings.Builder
+
+	for _, name := range theme.names {
+		token := theme.tokens[name]
+		switch {
+		case !dark:
+			css.WriteString("--" + name + ":" + token.Light + ";")
+		case token.Dark != "":
+			css.WriteString("--" + name + ":" + token.Dark + ";")
+		}
+	}
+
+	return css.String()
+}
+
+// light on :root, dark when preferred. data-theme="light" or "dark" on any
+// element overrides both for it and its children
+func (theme *Theme) CSS() string {
+	light := theme.properties(false)
+	dark := theme.properties(true)
+
+	css := ":root{" + light + "}"
+	if dark == "" {
+		return css
+	}
+
+	return css +
+		"@media (prefers-color-scheme: dark){" +
+		":root:not([data-theme=light]){" + dark + "}}" +
+		"[data-theme=light]{" + light + "}" +
+		"[data-theme=dark]{" + dark + "}"
+}
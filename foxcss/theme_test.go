@@ -0,0 +1,56 @@
+package foxcss
+
+import "testing"
+
+func TestNewTheme(t *testing.T) {
+	theme, err := NewTheme(map[string]ThemeToken{
+		"accent":  {Light: "#f0f", Dark: "#0ff"},
+		"space-2": {Light: "8px"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ":root{--accent:#f0f;--space-2:8px;}" +
+		"@media (prefers-color-scheme: dark){" +
+		":root:not([data-theme=light]){--accent:#0ff;}}" +
+		"[data-theme=light]{--accent:#f0f;--space-2:8px;}" +
+		"[data-theme=dark]{--accent:#0ff;}"
+	if theme.CSS() != want {
+		t.Errorf("got\n%s\nwant\n%s", theme.CSS(), want)
+	}
+
+	if theme.Var("accent") != "var(--accent)" {
+		t.Errorf("got %s", theme.Var("accent"))
+	}
+
+	for _, tokens := range []map[string]ThemeToken{
+		{"bad name": {Light: "1"}},
+		{"empty": {}},
+		{"escape": {Light: "red;} body{display:none"}},
+		{"dark-escape": {Light: "red", Dark: "</style>"}},
+		{"double-quote": {Light: `"red`}},
+		{"single-quote": {Light: "'red"}},
+		{"newline-quote": {Light: "'red\n'"}},
+		{"backslash": {Light: `red\`}},
+		{"block-comment": {Light: "red /* x"}},
+		{"line-comment": {Light: "red // x"}},
+		{"paren": {Light: "calc(1px + 2px"}},
+		{"url": {Light: "url(a.png"}},
+	} {
+		_, err := NewTheme(tokens)
+		if err == nil {
+			t.Errorf("expected error for %v", tokens)
+		}
+	}
+
+	_, err = NewTheme(map[string]ThemeToken{
+		"font":  {Light: `"Inter", 'Noto Sans', sans-serif`},
+		"image": {Light: "url(https://x.com/a.png)", Dark: `url("//x.com/b.png")`},
+		"size":  {Light: "calc(1px + var(--x, 2px))"},
+		"icon":  {Light: `"\f101"`},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}